@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"citadel/internal/password"
+
+	"github.com/spf13/viper"
+)
+
+// loadConfig sets defaults and reads config.json from the working directory.
+func loadConfig() error {
+	// Set defaults
+	viper.SetDefault("server.port", "8080")
+	viper.SetDefault("database.path", "./citadel.db")
+	viper.SetDefault("redis.host", "localhost")
+	viper.SetDefault("redis.port", "6379")
+	viper.SetDefault("redis.db", 0)
+	viper.SetDefault("server.app_url", "http://localhost:3000")
+	viper.SetDefault("mail.backend", "log")
+	viper.SetDefault("auth.require_verified_email", false)
+	viper.SetDefault("auth.cookies.enabled", false)
+	viper.SetDefault("auth.revocation.cache_ttl", "30s")
+	viper.SetDefault("auth.revocation.cache_size", 100000)
+	viper.SetDefault("auth.revocation.fail_open", false)
+	viper.SetDefault("auth.deletion_grace_period", "720h")
+	viper.SetDefault("export.ttl", "24h")
+	viper.SetDefault("oidc.issuer", "http://localhost:8080")
+	viper.SetDefault("webauthn.rp_id", "localhost")
+	viper.SetDefault("webauthn.rp_name", "Citadel")
+	viper.SetDefault("password.argon2.memory_kib", password.DefaultParams.Memory)
+	viper.SetDefault("password.argon2.iterations", password.DefaultParams.Iterations)
+	viper.SetDefault("password.argon2.parallelism", password.DefaultParams.Parallelism)
+	viper.SetDefault("password.policy.min_length", 8)
+	viper.SetDefault("password.policy.max_length", 128)
+	viper.SetDefault("password.policy.min_score", 2)
+
+	// Load configuration
+	viper.SetConfigName("config")
+	viper.SetConfigType("json")
+	viper.AddConfigPath(".")
+
+	// Read config file
+	return viper.ReadInConfig()
+}
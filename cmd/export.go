@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"citadel/internal/audit"
+	"citadel/internal/cache"
+	"citadel/internal/database"
+	"citadel/internal/export"
+	"citadel/internal/user"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <email>",
+	Short: "Export a user's personal data",
+	Long: `Write a zip archive of everything stored about a user, the same archive
+they can request themselves with POST /me/export`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExport,
+}
+
+func init() {
+	exportCmd.Flags().StringP("output", "o", "", "archive path (default citadel-export-<id>.zip)")
+	userCmd.AddCommand(exportCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	db, err := database.New(viper.GetString("database.path"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Sessions live in Redis
+	rdb, err := cache.New(ctx, cache.Config{
+		Host:     viper.GetString("redis.host"),
+		Port:     viper.GetString("redis.port"),
+		Password: viper.GetString("redis.password"),
+		DB:       viper.GetInt("redis.db"),
+	})
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	u, err := user.ByEmail(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+
+	path, _ := cmd.Flags().GetString("output")
+	if path == "" {
+		path = fmt.Sprintf("citadel-export-%d.zip", u.UserId)
+	}
+	// The archive holds personal data, so only the owner may read it
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer f.Close()
+
+	manifest, err := export.Write(ctx, db, rdb, u.UserId, f)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	e := audit.Event{Type: audit.EventDataExport, TargetID: audit.ID(u.UserId)}
+	if err := audit.Record(ctx, db, e, map[string]any{"source": "cli"}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Exported %d files for %s to %s\n",
+		len(manifest.Files), u.Email, path)
+	return nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/database"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// purgeInterval is how often the server purges deleted accounts.
+const purgeInterval = time.Hour
+
+var purgeDeletedCmd = &cobra.Command{
+	Use:   "purge-deleted",
+	Short: "Purge deleted users whose grace period is over",
+	Long: `Permanently remove deleted users whose grace period is over. The server
+does this every hour on its own`,
+	Args: cobra.NoArgs,
+	RunE: runPurgeDeleted,
+}
+
+func init() {
+	userCmd.AddCommand(purgeDeletedCmd)
+}
+
+func runPurgeDeleted(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	db, err := database.New(viper.GetString("database.path"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userIDs, err := purgeDeleted(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Purged %d deleted users\n", len(userIDs))
+	return nil
+}
+
+// purgeDeletedLoop purges deleted users every purgeInterval until ctx is done.
+func purgeDeletedLoop(ctx context.Context, db *sqlx.DB, logger *slog.Logger) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		userIDs, err := purgeDeleted(ctx, db)
+		if err != nil {
+			logger.Error("Failed to purge deleted users", "error", err)
+		} else if len(userIDs) > 0 {
+			logger.Info("Purged deleted users", "user_ids", userIDs)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeleted purges deleted users whose grace period is over and records
+// each purge in the audit log.
+func purgeDeleted(ctx context.Context, db *sqlx.DB) ([]int64, error) {
+	userIDs, err := user.PurgeDeleted(ctx, db, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range userIDs {
+		e := audit.Event{Type: audit.EventUserPurge, TargetID: audit.ID(userID)}
+		if err := audit.Record(ctx, db, e, nil); err != nil {
+			return userIDs, fmt.Errorf("failed to record purge of user %d: %w", userID, err)
+		}
+	}
+	return userIDs, nil
+}
@@ -22,4 +22,5 @@ func Execute() {
 
 func init() {
 	root.AddCommand(serveCmd)
+	root.AddCommand(userCmd)
 }
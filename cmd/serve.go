@@ -1,17 +1,23 @@
 package cmd
 
 import (
+	"expvar"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 
 	"citadel/internal/auth"
 	"citadel/internal/cache"
 	"citadel/internal/database"
 	"citadel/internal/logging"
+	"citadel/internal/mail"
+	"citadel/internal/middleware"
+	"citadel/internal/password"
 	"citadel/route"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -26,20 +32,8 @@ var serveCmd = &cobra.Command{
 func runServe(cmd *cobra.Command, args []string) {
 	ctx := cmd.Context()
 
-	// Set defaults
-	viper.SetDefault("server.port", "8080")
-	viper.SetDefault("database.path", "./citadel.db")
-	viper.SetDefault("redis.host", "localhost")
-	viper.SetDefault("redis.port", "6379")
-	viper.SetDefault("redis.db", 0)
-
 	// Load configuration
-	viper.SetConfigName("config")
-	viper.SetConfigType("json")
-	viper.AddConfigPath(".")
-
-	// Read config file
-	if err := viper.ReadInConfig(); err != nil {
+	if err := loadConfig(); err != nil {
 		slog.Error("Failed to read config file", "error", err)
 		os.Exit(1)
 	}
@@ -87,25 +81,172 @@ func runServe(cmd *cobra.Command, args []string) {
 	}
 	defer rdb.Close()
 
+	// Answer revocation checks from memory, invalidated over Redis pub/sub
+	revocations := cache.NewRevocations(rdb, cache.RevocationConfig{
+		TTL:      viper.GetDuration("auth.revocation.cache_ttl"),
+		Size:     viper.GetInt("auth.revocation.cache_size"),
+		FailOpen: viper.GetBool("auth.revocation.fail_open"),
+	})
+	go revocations.Run(ctx)
+
 	// Create JWT service from config
-	jwtSecret := viper.GetString("jwt.secret")
-	if jwtSecret == "" {
-		logger.Error("JWT secret not configured")
+	var keyConfigs []struct {
+		ID   string `mapstructure:"id"`
+		Path string `mapstructure:"path"`
+	}
+	if err := viper.UnmarshalKey("jwt.keys", &keyConfigs); err != nil {
+		logger.Error("Failed to parse JWT key configuration", "error", err)
+		os.Exit(1)
+	}
+	if len(keyConfigs) == 0 {
+		logger.Error("JWT keys not configured")
+		os.Exit(1)
+	}
+	keys := make([]*auth.Key, 0, len(keyConfigs))
+	for _, kc := range keyConfigs {
+		key, err := auth.LoadKey(kc.ID, kc.Path)
+		if err != nil {
+			logger.Error("Failed to load JWT key", "error", err)
+			os.Exit(1)
+		}
+		keys = append(keys, key)
+	}
+	issuer, err := auth.NewIssuer(viper.GetString("jwt.signing_key"), keys...)
+	if err != nil {
+		logger.Error("Failed to create JWT issuer", "error", err)
+		os.Exit(1)
+	}
+	logger.Info(
+		"Loaded JWT keys",
+		"count", len(keys),
+		"signing_key", viper.GetString("jwt.signing_key"),
+	)
+
+	// Initialize mailer
+	mailer, err := mail.New(mail.Config{
+		Backend:  viper.GetString("mail.backend"),
+		From:     viper.GetString("mail.from"),
+		Host:     viper.GetString("mail.smtp.host"),
+		Port:     viper.GetString("mail.smtp.port"),
+		Username: viper.GetString("mail.smtp.username"),
+		Password: viper.GetString("mail.smtp.password"),
+		Dir:      viper.GetString("mail.dir"),
+	}, logger)
+	if err != nil {
+		logger.Error("Failed to initialize mailer", "error", err)
+		os.Exit(1)
+	}
+
+	// Create password hasher; peppers are kept by ID so they can be rotated
+	// while hashes made with an older one still verify
+	var pepperConfigs []struct {
+		ID    string `mapstructure:"id"`
+		Value string `mapstructure:"value"`
+	}
+	if err := viper.UnmarshalKey("password.peppers", &pepperConfigs); err != nil {
+		logger.Error("Failed to parse password pepper configuration", "error", err)
+		os.Exit(1)
+	}
+	peppers := make(map[string]string, len(pepperConfigs))
+	for _, pc := range pepperConfigs {
+		peppers[pc.ID] = pc.Value
+	}
+	hasher, err := password.New(password.Config{
+		Params: password.Params{
+			Memory:      viper.GetUint32("password.argon2.memory_kib"),
+			Iterations:  viper.GetUint32("password.argon2.iterations"),
+			Parallelism: uint8(viper.GetUint("password.argon2.parallelism")),
+		},
+		Peppers:  peppers,
+		PepperID: viper.GetString("password.pepper_id"),
+	})
+	if err != nil {
+		logger.Error("Failed to create password hasher", "error", err)
+		os.Exit(1)
+	}
+
+	// Load password policy; the breached password list is optional
+	policy := &password.Policy{
+		MinLength: viper.GetInt("password.policy.min_length"),
+		MaxLength: viper.GetInt("password.policy.max_length"),
+		MinScore:  viper.GetInt("password.policy.min_score"),
+	}
+	if path := viper.GetString("password.policy.breached_file"); path != "" {
+		policy.Breached, err = password.OpenBreached(path)
+		if err != nil {
+			logger.Error("Failed to open breached password list", "error", err)
+			os.Exit(1)
+		}
+		defer policy.Breached.Close()
+		logger.Info("Opened breached password list", "path", path,
+			"bytes", policy.Breached.Size())
+	}
+
+	// Initialize WebAuthn relying party; passkeys are created on the web app's origin
+	origins := viper.GetStringSlice("webauthn.origins")
+	if len(origins) == 0 {
+		origins = []string{strings.TrimSuffix(viper.GetString("server.app_url"), "/")}
+	}
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          viper.GetString("webauthn.rp_id"),
+		RPDisplayName: viper.GetString("webauthn.rp_name"),
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		logger.Error("Failed to initialize WebAuthn", "error", err)
+		os.Exit(1)
+	}
+
+	trustedProxies, err := middleware.ParseTrustedProxies(
+		viper.GetStringSlice("server.trusted_proxies"),
+	)
+	if err != nil {
+		logger.Error("Failed to parse trusted proxies", "error", err)
 		os.Exit(1)
 	}
-	issuer := auth.NewIssuer(jwtSecret)
 
 	// Initialize routes
 	routeConfig := route.Config{
-		Db:          db,
-		Redis:       rdb,
-		Issuer:      issuer,
-		Logger:      logger,
-		LogManager:  logManager,
-		Broadcaster: broadcaster,
+		Db:                   db,
+		Redis:                rdb,
+		Revocations:          revocations,
+		Issuer:               issuer,
+		Logger:               logger,
+		LogManager:           logManager,
+		Broadcaster:          broadcaster,
+		Mailer:               mailer,
+		Hasher:               hasher,
+		PasswordPolicy:       policy,
+		AppURL:               strings.TrimSuffix(viper.GetString("server.app_url"), "/"),
+		RequireVerifiedEmail: viper.GetBool("auth.require_verified_email"),
+		IssuerURL:            strings.TrimSuffix(viper.GetString("oidc.issuer"), "/"),
+		WebAuthn:             wa,
+		Cookies: route.CookieConfig{
+			Enabled: viper.GetBool("auth.cookies.enabled"),
+			Domain:  viper.GetString("auth.cookies.domain"),
+		},
+		TrustedProxies:      trustedProxies,
+		DeletionGracePeriod: viper.GetDuration("auth.deletion_grace_period"),
+		ExportTTL:           viper.GetDuration("export.ttl"),
 	}
 	handler := route.Initialize(routeConfig)
 
+	// Purge deleted accounts once their grace period is over
+	go purgeDeletedLoop(ctx, db, logger)
+
+	// Serve metrics, such as revocation cache hit rates, on a separate
+	// listener so they are not exposed with the API
+	if metricsAddr := viper.GetString("metrics.addr"); metricsAddr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("GET /debug/vars", expvar.Handler())
+		go func() {
+			logger.Info("Starting metrics server", "addr", metricsAddr)
+			if err := http.ListenAndServe(metricsAddr, metrics); err != nil {
+				logger.Error("Metrics server error", "error", err)
+			}
+		}()
+	}
+
 	// Create HTTP server
 	serverPort := viper.GetString("server.port")
 	server := &http.Server{
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+
+	"citadel/internal/database"
+	"citadel/internal/user"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage Citadel users",
+	Long:  `The user command groups administrative operations on user accounts`,
+}
+
+var grantRoleCmd = &cobra.Command{
+	Use:   "grant-role <email> <role>",
+	Short: "Grant a role to a user",
+	Long:  `Grant a role to a user. Use this to bootstrap the first admin account`,
+	Args:  cobra.ExactArgs(2),
+	RunE:  runGrantRole,
+}
+
+var revokeRoleCmd = &cobra.Command{
+	Use:   "revoke-role <email> <role>",
+	Short: "Revoke a role from a user",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runRevokeRole,
+}
+
+func init() {
+	userCmd.AddCommand(grantRoleCmd)
+	userCmd.AddCommand(revokeRoleCmd)
+}
+
+func runGrantRole(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	db, err := database.New(viper.GetString("database.path"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	u, err := user.ByEmail(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+	if err := user.GrantRole(ctx, db, u.UserId, args[1]); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Granted role %s to %s\n", args[1], u.Email)
+	return nil
+}
+
+func runRevokeRole(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	db, err := database.New(viper.GetString("database.path"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	u, err := user.ByEmail(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+	revoked, err := user.RevokeRole(ctx, db, u.UserId, args[1])
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s does not have role %s\n", u.Email, args[1])
+		return nil
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Revoked role %s from %s\n", args[1], u.Email)
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package audit records security-relevant events in the append-only
+// audit_events table, so they outlive log rotation and can be queried.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Event types.
+const (
+	EventRegister       = "register"
+	EventLoginSuccess   = "login_success"
+	EventLoginFailure   = "login_failure"
+	EventRefresh        = "refresh"
+	EventLogout         = "logout"
+	EventUserUpdate     = "user_update"
+	EventPasswordReset  = "password_reset"
+	EventTokenRevoke    = "token_revoke"
+	EventRoleGrant      = "role_grant"
+	EventRoleRevoke     = "role_revoke"
+	EventUserSuspend    = "user_suspend"
+	EventUserUnsuspend  = "user_unsuspend"
+	EventAPITokenCreate = "api_token_create"
+	EventImpersonate    = "impersonation_start"
+	EventImpersonateEnd = "impersonation_end"
+	EventUserDelete     = "user_delete"
+	EventUserRestore    = "user_restore"
+	EventUserPurge      = "user_purge"
+	EventDataExport     = "data_export"
+)
+
+// Event is one entry in the audit log. ActorID is the user who acted and
+// TargetID the account acted on; either may be unknown, such as for a failed
+// login to an address that has no account. ImpersonatorID is set when the
+// actor was being impersonated by an admin.
+type Event struct {
+	ID             int64          `db:"event_id"        json:"id"`
+	Type           string         `db:"event_type"      json:"type"`
+	ActorID        sql.NullInt64  `db:"actor_id"        json:"-"`
+	TargetID       sql.NullInt64  `db:"target_id"       json:"-"`
+	ImpersonatorID sql.NullInt64  `db:"impersonator_id" json:"-"`
+	IP             string         `db:"ip"              json:"ip"`
+	UserAgent      string         `db:"user_agent"      json:"user_agent"`
+	RequestID      string         `db:"request_id"      json:"request_id"`
+	Details        sql.NullString `db:"details"         json:"-"`
+	CreatedAt      time.Time      `db:"created_at"      json:"created_at"`
+}
+
+// MarshalJSON flattens the nullable columns.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	out := struct {
+		plain
+		ActorID        *int64          `json:"actor_id,omitempty"`
+		TargetID       *int64          `json:"target_id,omitempty"`
+		ImpersonatorID *int64          `json:"impersonator_id,omitempty"`
+		Details        json.RawMessage `json:"details,omitempty"`
+	}{plain: plain(e)}
+	if e.ActorID.Valid {
+		out.ActorID = &e.ActorID.Int64
+	}
+	if e.TargetID.Valid {
+		out.TargetID = &e.TargetID.Int64
+	}
+	if e.ImpersonatorID.Valid {
+		out.ImpersonatorID = &e.ImpersonatorID.Int64
+	}
+	if e.Details.Valid {
+		out.Details = json.RawMessage(e.Details.String)
+	}
+	return json.Marshal(out)
+}
+
+// ID wraps a user ID for Event.ActorID and Event.TargetID.
+func ID(userID int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: userID, Valid: true}
+}
+
+// Record appends an event. details, if not nil, is stored as JSON.
+func Record(ctx context.Context, db *sqlx.DB, e Event, details map[string]any) error {
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit details: %w", err)
+		}
+		e.Details = sql.NullString{String: string(data), Valid: true}
+	}
+
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO audit_events (
+			event_type, actor_id, target_id, impersonator_id, ip, user_agent, request_id,
+			details
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Type,
+		e.ActorID,
+		e.TargetID,
+		e.ImpersonatorID,
+		e.IP,
+		e.UserAgent,
+		e.RequestID,
+		e.Details,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// Filter narrows a List query. Zero fields match everything. UserID matches
+// events where the user is the actor, the target or the impersonator.
+type Filter struct {
+	ActorID  *int64
+	TargetID *int64
+	UserID   *int64
+	Types    []string
+	Since    *time.Time
+	Until    *time.Time
+	// Before is a cursor: only events older than this event ID are returned
+	Before int64
+	Limit  int
+}
+
+// List returns matching events, newest first.
+func List(ctx context.Context, db *sqlx.DB, f Filter) ([]Event, error) {
+	where := []string{}
+	args := []any{}
+
+	if f.ActorID != nil {
+		where = append(where, "actor_id = ?")
+		args = append(args, *f.ActorID)
+	}
+	if f.TargetID != nil {
+		where = append(where, "target_id = ?")
+		args = append(args, *f.TargetID)
+	}
+	if f.UserID != nil {
+		where = append(where, "(actor_id = ? OR target_id = ? OR impersonator_id = ?)")
+		args = append(args, *f.UserID, *f.UserID, *f.UserID)
+	}
+	if len(f.Types) > 0 {
+		where = append(where, "event_type IN (?"+strings.Repeat(", ?", len(f.Types)-1)+")")
+		for _, t := range f.Types {
+			args = append(args, t)
+		}
+	}
+	if f.Since != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format(time.DateTime))
+	}
+	if f.Until != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until.UTC().Format(time.DateTime))
+	}
+	if f.Before > 0 {
+		where = append(where, "event_id < ?")
+		args = append(args, f.Before)
+	}
+
+	query := `SELECT * FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY event_id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	events := []Event{}
+	if err := db.SelectContext(ctx, &events, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return events, nil
+}
@@ -14,50 +14,149 @@ const (
 	audience = "citadel-api"
 )
 
+var validMethods = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
 type Claims struct {
-	UserId   int64  `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	UserId      int64    `json:"user_id"`
+	Username    string   `json:"username"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	// Scope is the space-delimited list of scopes the token was granted. It
+	// only narrows the token: routes still check the user's permissions.
+	Scope string `json:"scope,omitempty"`
+	// TokenEpoch is the user's token epoch when the token was issued. Bumping
+	// the epoch revokes every token issued before.
+	TokenEpoch int64 `json:"epoch,omitempty"`
+	// Actor is set on impersonation tokens and names the admin acting as the
+	// user, following the RFC 8693 act claim.
+	Actor *Actor `json:"act,omitempty"`
+	// APITokenID is set when the request was authenticated with a personal
+	// access token instead of a signed JWT. It never appears in a JWT.
+	APITokenID int64 `json:"-"`
 	jwt.RegisteredClaims
 }
 
+// Subject describes the user an access token is issued for.
+type Subject struct {
+	UserId      int64
+	Username    string
+	Email       string
+	Roles       []string
+	Permissions []string
+	SessionID   string
+	Scope       string
+	TokenEpoch  int64
+}
+
+// Issuer signs tokens with a single active key and verifies them against
+// every configured key, selected by the kid header.
 type Issuer struct {
-	secret []byte
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+// NewIssuer creates an issuer that signs with the key named signingKeyID.
+// The remaining keys are only used for verification during rotation.
+func NewIssuer(signingKeyID string, keys ...*Key) (*Issuer, error) {
+	s := &Issuer{keys: make(map[string]*Key, len(keys))}
+	for _, key := range keys {
+		if _, exists := s.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id: %s", key.ID)
+		}
+		s.keys[key.ID] = key
+		s.order = append(s.order, key.ID)
+	}
+
+	signing, ok := s.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKeyID)
+	}
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+	s.signing = signing
+
+	return s, nil
 }
 
-func NewIssuer(secret string) *Issuer {
-	return &Issuer{secret: []byte(secret)}
+// accessTTL is how long an access token is valid.
+const accessTTL = 5 * time.Minute
+
+// GenerateAccessToken signs a short-lived access token for the subject.
+// The claims are returned alongside so callers can track the token ID.
+func (s *Issuer) GenerateAccessToken(subject Subject) (string, *Claims, error) {
+	return s.generateAccessToken(subject, nil, accessTTL)
 }
 
-func (s *Issuer) GenerateAccessToken(id int64, email, username string) (string, error) {
+func (s *Issuer) generateAccessToken(
+	subject Subject,
+	actor *Actor,
+	ttl time.Duration,
+) (string, *Claims, error) {
 	now := time.Now()
 	claims := Claims{
-		UserId:   id,
-		Username: username,
-		Email:    email,
+		UserId:      subject.UserId,
+		Username:    subject.Username,
+		Email:       subject.Email,
+		Roles:       subject.Roles,
+		Permissions: subject.Permissions,
+		SessionID:   subject.SessionID,
+		Scope:       subject.Scope,
+		TokenEpoch:  subject.TokenEpoch,
+		Actor:       actor,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        uuid.New().String(),
-			Subject:   strconv.FormatInt(id, 10),
+			Subject:   strconv.FormatInt(subject.UserId, 10),
 			Issuer:    issuer,
 			Audience:  jwt.ClaimStrings{audience},
-			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.secret)
+	token, err := s.sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &claims, nil
+}
+
+// sign signs the claims with the active key and stamps its kid in the header.
+func (s *Issuer) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.method(), claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.private)
+}
+
+// keyFunc resolves the verification key from the token's kid header.
+func (s *Issuer) keyFunc(token *jwt.Token) (any, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return nil, fmt.Errorf("missing kid header")
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("algorithm %s does not match key %s", token.Method.Alg(), kid)
+	}
+	return key.public, nil
 }
 
 func (s *Issuer) Validate(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&Claims{},
-		func(token *jwt.Token) (any, error) {
-			return s.secret, nil
-		},
-		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		s.keyFunc,
+		jwt.WithValidMethods(validMethods),
 		jwt.WithIssuer(issuer),
 		jwt.WithAudience(audience),
 	)
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newKey generates a key named id. RSA keys are generated when rsaKey is set,
+// Ed25519 keys otherwise.
+func newKey(t *testing.T, id string, rsaKey bool) *Key {
+	t.Helper()
+
+	var private crypto.Signer
+	var err error
+	if rsaKey {
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(id, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// publicOnly returns a copy of key that can only verify.
+func publicOnly(t *testing.T, key *Key) *Key {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ParseKey(key.ID, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public
+}
+
+func newIssuer(t *testing.T, signingKeyID string, keys ...*Key) *Issuer {
+	t.Helper()
+
+	issuer, err := NewIssuer(signingKeyID, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer
+}
+
+// signWith signs a valid access token with private, setting the kid header to
+// kid unless it is empty.
+func signWith(t *testing.T, method jwt.SigningMethod, private any, kid string) string {
+	t.Helper()
+
+	now := time.Now()
+	token := jwt.NewWithClaims(method, Claims{
+		UserId: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestValidateSelectsKeyByKid(t *testing.T) {
+	current := newKey(t, "2026-02", false)
+	previous := newKey(t, "2026-01", true)
+	stranger := newKey(t, "2025-12", false)
+	// Signs with the ID of the current key, but is not it
+	impostor := newKey(t, "2026-02", false)
+
+	verifier := newIssuer(t, "2026-02", current, publicOnly(t, previous))
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{
+			name:  "current key",
+			token: signWith(t, jwt.SigningMethodEdDSA, current.private, "2026-02"),
+			valid: true,
+		},
+		{
+			name:  "previous key kept for verification",
+			token: signWith(t, jwt.SigningMethodRS256, previous.private, "2026-01"),
+			valid: true,
+		},
+		{
+			name:  "missing kid",
+			token: signWith(t, jwt.SigningMethodEdDSA, current.private, ""),
+		},
+		{
+			name:  "unknown kid",
+			token: signWith(t, jwt.SigningMethodEdDSA, stranger.private, "2025-12"),
+		},
+		{
+			name:  "kid of another key",
+			token: signWith(t, jwt.SigningMethodEdDSA, current.private, "2026-01"),
+		},
+		{
+			name:  "signed by a key reusing a kid",
+			token: signWith(t, jwt.SigningMethodEdDSA, impostor.private, "2026-02"),
+		},
+		{
+			name:  "unsigned",
+			token: signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "2026-02"),
+		},
+		{
+			name: "HMAC keyed with the public key",
+			token: signWith(
+				t,
+				jwt.SigningMethodHS256,
+				[]byte(current.public.(ed25519.PublicKey)),
+				"2026-02",
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Validate(tt.token)
+			if tt.valid && err != nil {
+				t.Fatalf("got error %v, want a valid token", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatalf("got claims %+v, want the token rejected", claims)
+			}
+		})
+	}
+}
+
+func TestIssuerSignsWithActiveKey(t *testing.T) {
+	previous := newKey(t, "2026-01", true)
+	current := newKey(t, "2026-02", false)
+
+	signer := newIssuer(t, "2026-02", previous, current)
+	token, _, err := signer.GenerateAccessToken(Subject{UserId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := parsed.Header["kid"]; kid != "2026-02" {
+		t.Errorf("got kid %v, want 2026-02", kid)
+	}
+	if parsed.Method.Alg() != current.Algorithm {
+		t.Errorf("got alg %s, want %s", parsed.Method.Alg(), current.Algorithm)
+	}
+
+	// Once the key is retired from signing, its tokens still verify
+	rotated := newIssuer(t, "2026-03", newKey(t, "2026-03", false), publicOnly(t, current))
+	if _, err := rotated.Validate(token); err != nil {
+		t.Errorf("got error %v after rotation, want a valid token", err)
+	}
+}
+
+func TestNewIssuerRejected(t *testing.T) {
+	key := newKey(t, "a", false)
+	other := newKey(t, "a", false)
+
+	tests := []struct {
+		name    string
+		signing string
+		keys    []*Key
+	}{
+		{"no keys", "a", nil},
+		{"unknown signing key", "b", []*Key{key}},
+		{"signing key without private key", "a", []*Key{publicOnly(t, key)}},
+		{"duplicate kid", "a", []*Key{key, other}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewIssuer(tt.signing, tt.keys...); err == nil {
+				t.Error("got an issuer, want an error")
+			}
+		})
+	}
+}
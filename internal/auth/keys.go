@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a named asymmetric key used to sign or verify tokens.
+// Keys loaded from a public key only can verify but never sign, which is
+// how retired keys stay around until the tokens they signed have expired.
+type Key struct {
+	ID        string
+	Algorithm string
+	private   crypto.PrivateKey
+	public    crypto.PublicKey
+}
+
+// LoadKey reads a PEM encoded key from disk.
+// Accepts PKCS#8 or PKCS#1 private keys and PKIX public keys (RSA or Ed25519).
+func LoadKey(id, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", id, err)
+	}
+	return ParseKey(id, data)
+}
+
+// ParseKey parses a PEM encoded key.
+func ParseKey(id string, data []byte) (*Key, error) {
+	if id == "" {
+		return nil, fmt.Errorf("key id is required")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM block found", id)
+	}
+
+	var private crypto.PrivateKey
+	var public crypto.PublicKey
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: failed to parse private key: %w", id, err)
+		}
+		private = parsed
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: failed to parse private key: %w", id, err)
+		}
+		private = parsed
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: failed to parse public key: %w", id, err)
+		}
+		public = parsed
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM block type %q", id, block.Type)
+	}
+
+	if private != nil {
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %s: unsupported private key type %T", id, private)
+		}
+		public = signer.Public()
+	}
+
+	key := &Key{ID: id, private: private, public: public}
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %s: RSA keys must be at least 2048 bits", id)
+		}
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+	case ed25519.PublicKey:
+		key.Algorithm = jwt.SigningMethodEdDSA.Alg()
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", id, public)
+	}
+
+	return key, nil
+}
+
+// CanSign reports whether the key holds private key material.
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
@@ -0,0 +1,31 @@
+package auth
+
+import "slices"
+
+// Roles seeded by the schema.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Permissions checked by routes. The role to permission mapping lives in
+// the role_permissions table.
+const (
+	PermissionUsersRead     = "users:read"
+	PermissionUsersWrite    = "users:write"
+	PermissionRolesManage   = "roles:manage"
+	PermissionLogsStream    = "logs:stream"
+	PermissionClientsManage = "clients:manage"
+	PermissionAuditRead     = "audit:read"
+	PermissionImpersonate   = "users:impersonate"
+)
+
+// HasRole reports whether the token carries the role.
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// HasPermission reports whether the token carries the permission.
+func (c *Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ScopeAccount grants the self-service routes that manage the user's own
+// sessions, API tokens, passkeys and MFA. Every other scope is named after
+// the permission it unlocks.
+const ScopeAccount = "account"
+
+// ErrInvalidScope is returned when a requested scope is unknown or not held.
+var ErrInvalidScope = errors.New("invalid scope")
+
+// FullScope returns the space-delimited scope granted to a token that was
+// not narrowed: account management plus every permission the user holds.
+func FullScope(permissions []string) string {
+	return strings.Join(append([]string{ScopeAccount}, permissions...), " ")
+}
+
+// GrantScope narrows the available scope to the space-delimited scopes that
+// were requested. An empty request grants everything available.
+func GrantScope(requested, available string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return available, nil
+	}
+	held := strings.Fields(available)
+	for _, scope := range scopes {
+		if !slices.Contains(held, scope) {
+			return "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	slices.Sort(scopes)
+	return strings.Join(slices.Compact(scopes), " "), nil
+}
+
+// RetainScope drops the scopes of an earlier grant that are no longer
+// available, such as after one of the user's roles was revoked.
+func RetainScope(granted, available string) string {
+	held := strings.Fields(available)
+	scopes := slices.DeleteFunc(strings.Fields(granted), func(scope string) bool {
+		return !slices.Contains(held, scope)
+	})
+	return strings.Join(scopes, " ")
+}
+
+// HasScope reports whether the token was granted the scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
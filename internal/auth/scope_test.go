@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGrantScope(t *testing.T) {
+	const available = "account users:read users:write"
+
+	tests := []struct {
+		name      string
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"nothing requested", "", available, nil},
+		{"blank request", "   ", available, nil},
+		{"one scope", "users:read", "users:read", nil},
+		{"sorted and deduplicated", "users:write account users:write", "account users:write", nil},
+		{"unknown scope", "users:read admin", "", ErrInvalidScope},
+		{"scope not held", "logs:read", "", ErrInvalidScope},
+		{"prefix of a held scope", "users", "", ErrInvalidScope},
+		{"held scopes run together", "account,users:read", "", ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GrantScope(tt.requested, available)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got scope %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetainScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		granted   string
+		available string
+		want      string
+	}{
+		{"all held", "account users:read", "account users:read logs:read", "account users:read"},
+		{"permission revoked", "account users:write", "account users:read", "account"},
+		{"everything revoked", "users:write", "account", ""},
+		{"nothing granted", "", "account", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RetainScope(tt.granted, tt.available); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	claims := &Claims{Scope: "account users:read"}
+
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{"account", true},
+		{"users:read", true},
+		{"users:write", false},
+		{"users", false},
+		{"account users:read", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := claims.HasScope(tt.scope); got != tt.want {
+			t.Errorf("HasScope(%q) = %t, want %t", tt.scope, got, tt.want)
+		}
+	}
+
+	if (&Claims{}).HasScope(ScopeAccount) {
+		t.Error("a token without scopes has the account scope")
+	}
+}
+
+func TestFullScope(t *testing.T) {
+	if got, want := FullScope([]string{"users:read"}), "account users:read"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := FullScope(nil); got != ScopeAccount {
+		t.Errorf("got %q without permissions, want %q", got, ScopeAccount)
+	}
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrEpochNotCached is returned when a user's token epoch has to be read
+// from the database.
+var ErrEpochNotCached = errors.New("token epoch not cached")
+
+// tokenEpochTTL bounds how long an idle user's epoch stays in Redis.
+const tokenEpochTTL = time.Hour
+
+// GetTokenEpoch returns the user's cached token epoch.
+func GetTokenEpoch(ctx context.Context, c *redis.Client, userID int64) (int64, error) {
+	epoch, err := c.Get(ctx, fmt.Sprintf("token_epoch:%d", userID)).Int64()
+	if err == redis.Nil {
+		return 0, ErrEpochNotCached
+	}
+	return epoch, err
+}
+
+// SetTokenEpoch publishes a new token epoch for the user, invalidating it in
+// every instance's local revocation cache.
+func SetTokenEpoch(ctx context.Context, c *redis.Client, userID int64, epoch int64) error {
+	pipe := c.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf("token_epoch:%d", userID), epoch, tokenEpochTTL)
+	pipe.Publish(ctx, revocationChannel, fmt.Sprintf("epoch:%d", userID))
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// FillTokenEpoch caches an epoch read from the database. It never overwrites
+// a cached value, which may have been published by a concurrent bump after
+// the database read.
+func FillTokenEpoch(ctx context.Context, c *redis.Client, userID int64, epoch int64) error {
+	return c.SetNX(ctx, fmt.Sprintf("token_epoch:%d", userID), epoch, tokenEpochTTL).Err()
+}
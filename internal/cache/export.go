@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Export statuses.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// ErrExportNotFound is returned when a data export is unknown or expired.
+var ErrExportNotFound = errors.New("export not found or expired")
+
+// ErrExportInProgress is returned when the user already has an export being
+// built.
+var ErrExportInProgress = errors.New("export already in progress")
+
+// Export is a personal data export. The archive itself is fetched separately
+// with ExportArchive.
+type Export struct {
+	ID        string
+	UserID    int64
+	Status    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// startExportScript creates a pending export unless the user already has one
+// being built. The per-user lock expires on its own should the instance
+// building the export go away.
+var startExportScript = redis.NewScript(`
+if not redis.call('SET', KEYS[2], ARGV[1], 'NX', 'PX', ARGV[5]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'user_id', ARGV[2], 'status', 'pending', 'created_at', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+// StartExport records a pending export for the user. It expires after ttl,
+// and no other export can be started for the user until it is finished or
+// timeout has passed.
+func StartExport(
+	ctx context.Context,
+	c *redis.Client,
+	exportID string,
+	userID int64,
+	ttl time.Duration,
+	timeout time.Duration,
+) (*Export, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	keys := []string{
+		fmt.Sprintf("export:%s", exportID),
+		fmt.Sprintf("export_lock:%d", userID),
+	}
+
+	started, err := startExportScript.Run(
+		ctx,
+		c,
+		keys,
+		exportID,
+		userID,
+		now.Unix(),
+		ttl.Milliseconds(),
+		timeout.Milliseconds(),
+	).Bool()
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, ErrExportInProgress
+	}
+	return &Export{
+		ID:        exportID,
+		UserID:    userID,
+		Status:    ExportPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// finishExportScript stores the outcome of an export, unless it expired in
+// the meantime, and releases the user's lock if it still holds it.
+var finishExportScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'status', ARGV[2], 'archive', ARGV[3])
+end
+if redis.call('GET', KEYS[2]) == ARGV[1] then
+	redis.call('DEL', KEYS[2])
+end
+return 1
+`)
+
+// FinishExport stores the archive of a pending export and marks it ready.
+func FinishExport(
+	ctx context.Context,
+	c *redis.Client,
+	exportID string,
+	userID int64,
+	archive []byte,
+) error {
+	return finishExport(ctx, c, exportID, userID, ExportReady, archive)
+}
+
+// FailExport marks a pending export as failed.
+func FailExport(ctx context.Context, c *redis.Client, exportID string, userID int64) error {
+	return finishExport(ctx, c, exportID, userID, ExportFailed, nil)
+}
+
+func finishExport(
+	ctx context.Context,
+	c *redis.Client,
+	exportID string,
+	userID int64,
+	status string,
+	archive []byte,
+) error {
+	keys := []string{
+		fmt.Sprintf("export:%s", exportID),
+		fmt.Sprintf("export_lock:%d", userID),
+	}
+	return finishExportScript.Run(ctx, c, keys, exportID, status, archive).Err()
+}
+
+// GetExport returns an export without its archive.
+func GetExport(ctx context.Context, c *redis.Client, exportID string) (*Export, error) {
+	key := fmt.Sprintf("export:%s", exportID)
+
+	pipe := c.Pipeline()
+	fields := pipe.HMGet(ctx, key, "user_id", "status", "created_at")
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	values := fields.Val()
+	if values[0] == nil || ttl.Val() <= 0 {
+		return nil, ErrExportNotFound
+	}
+	userID, _ := strconv.ParseInt(values[0].(string), 10, 64)
+	status, _ := values[1].(string)
+	createdAt, _ := strconv.ParseInt(values[2].(string), 10, 64)
+	return &Export{
+		ID:        exportID,
+		UserID:    userID,
+		Status:    status,
+		CreatedAt: time.Unix(createdAt, 0).UTC(),
+		ExpiresAt: time.Now().Add(ttl.Val()).UTC().Truncate(time.Second),
+	}, nil
+}
+
+// ExportArchive returns the archive of a ready export.
+func ExportArchive(ctx context.Context, c *redis.Client, exportID string) ([]byte, error) {
+	archive, err := c.HGet(ctx, fmt.Sprintf("export:%s", exportID), "archive").Bytes()
+	if err == redis.Nil {
+		return nil, ErrExportNotFound
+	}
+	return archive, err
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TrackImpersonation records an impersonation token minted by the actor so
+// that they can end it, along with every other one they hold, in one call.
+func TrackImpersonation(
+	ctx context.Context,
+	c *redis.Client,
+	actorID int64,
+	jti string,
+	expiresAt time.Time,
+) error {
+	key := fmt.Sprintf("impersonations:%d", actorID)
+
+	ttl, err := c.TTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := c.TxPipeline()
+	// Drop entries for tokens that have already expired on their own
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(expiresAt.Unix()), Member: jti})
+	// Keep the set until its longest-lived token expires
+	if remaining := time.Until(expiresAt); remaining > ttl {
+		pipe.Expire(ctx, key, remaining)
+	}
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RevokeImpersonations blacklists every live impersonation token the actor
+// holds and returns how many there were.
+func RevokeImpersonations(ctx context.Context, c *redis.Client, actorID int64) (int, error) {
+	key := fmt.Sprintf("impersonations:%d", actorID)
+
+	now := time.Now()
+	jtis, err := c.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min: strconv.FormatInt(now.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := c.TxPipeline()
+	for _, z := range jtis {
+		ttl := time.Unix(int64(z.Score), 0).Sub(now)
+		if ttl > 0 {
+			pipe.Set(ctx, fmt.Sprintf("blacklist:%s", z.Member), "1", ttl)
+			publishRevocation(ctx, pipe, z.Member.(string))
+		}
+	}
+	pipe.Del(ctx, key)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return len(jtis), nil
+}
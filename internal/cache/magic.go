@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrMagicLinkNotFound is returned when a magic link is unknown, expired or
+// has already been used.
+var ErrMagicLinkNotFound = errors.New("magic link not found or expired")
+
+// ErrMagicLinkNonce is returned when a magic link is opened without the nonce
+// handed to the browser that requested it. The link is used up regardless.
+var ErrMagicLinkNonce = errors.New("magic link nonce mismatch")
+
+// magicHash keeps magic link tokens and nonces out of Redis in the clear.
+func magicHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveMagicLink stores a magic link for the user, bound to the nonce of the
+// browser that asked for it.
+func SaveMagicLink(
+	ctx context.Context,
+	c *redis.Client,
+	token string,
+	nonce string,
+	userID int64,
+	ttl time.Duration,
+) error {
+	key := fmt.Sprintf("magic_link:%s", magicHash(token))
+
+	pipe := c.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "nonce", magicHash(nonce))
+	pipe.Expire(ctx, key, ttl)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// consumeMagicLinkScript reads and deletes a magic link in one step, so two
+// concurrent requests cannot both use it.
+var consumeMagicLinkScript = redis.NewScript(`
+local link = redis.call('HMGET', KEYS[1], 'user_id', 'nonce')
+if not link[1] then
+	return false
+end
+redis.call('DEL', KEYS[1])
+return link
+`)
+
+// ConsumeMagicLink uses up a magic link and returns the user it signs in.
+func ConsumeMagicLink(ctx context.Context, c *redis.Client, token, nonce string) (int64, error) {
+	key := fmt.Sprintf("magic_link:%s", magicHash(token))
+
+	res, err := consumeMagicLinkScript.Run(ctx, c, []string{key}).StringSlice()
+	if err == redis.Nil {
+		return 0, ErrMagicLinkNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(res[1]), []byte(magicHash(nonce))) != 1 {
+		return 0, ErrMagicLinkNonce
+	}
+	userID, err := strconv.ParseInt(res[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user id in magic link: %w", err)
+	}
+	return userID, nil
+}
+
+// CountMagicLinkRequest counts a magic link request for the address and
+// returns the number made in the current window and how long it has left.
+func CountMagicLinkRequest(
+	ctx context.Context,
+	c *redis.Client,
+	email string,
+	window time.Duration,
+) (int64, time.Duration, error) {
+	return countRequest(ctx, c, "magic_link_requests:"+normalizeEmail(email), window)
+}
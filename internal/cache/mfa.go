@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RecordMFAAttempt counts a second-factor attempt against an MFA challenge
+// and returns the number of attempts so far, this one included. Attempts are
+// counted before the code is checked, so concurrent guesses cannot slip past
+// a cap on the count.
+func RecordMFAAttempt(
+	ctx context.Context,
+	c *redis.Client,
+	jti string,
+	ttl time.Duration,
+) (int64, error) {
+	key := fmt.Sprintf("mfa_attempts:%s", jti)
+
+	pipe := c.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrCodeNotFound is returned when an authorization code is unknown, expired
+// or has already been exchanged.
+var ErrCodeNotFound = errors.New("authorization code not found or expired")
+
+// AuthCode is what an OAuth authorization code is bound to.
+type AuthCode struct {
+	ClientID      string    `json:"client_id"`
+	UserID        int64     `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"code_challenge"`
+	AuthTime      time.Time `json:"auth_time"`
+}
+
+// SaveAuthCode stores an authorization code until it is exchanged or expires.
+func SaveAuthCode(
+	ctx context.Context,
+	c *redis.Client,
+	code string,
+	authCode AuthCode,
+	ttl time.Duration,
+) error {
+	data, err := json.Marshal(authCode)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, fmt.Sprintf("oauth_code:%s", code), data, ttl).Err()
+}
+
+// ConsumeAuthCode returns and deletes an authorization code in one step, so a
+// code can be exchanged at most once.
+func ConsumeAuthCode(ctx context.Context, c *redis.Client, code string) (*AuthCode, error) {
+	data, err := c.GetDel(ctx, fmt.Sprintf("oauth_code:%s", code)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var authCode AuthCode
+	if err := json.Unmarshal(data, &authCode); err != nil {
+		return nil, fmt.Errorf("invalid authorization code data: %w", err)
+	}
+	return &authCode, nil
+}
@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// revocationChannel carries invalidations for every instance's local
+// revocation cache. Payloads are "token:<jti>" or "epoch:<user id>".
+const revocationChannel = "revocations"
+
+// revocationMetrics counts local revocation cache lookups. Hit rates are
+// hits / (hits + misses) and epoch_hits / (epoch_hits + epoch_misses).
+var revocationMetrics = expvar.NewMap("revocation_cache")
+
+// RevocationConfig tunes the local revocation cache.
+type RevocationConfig struct {
+	// TTL bounds how long a token found not revoked, or a user's token epoch,
+	// is trusted without asking Redis again. It is also the longest a missed
+	// invalidation can go unnoticed.
+	TTL time.Duration
+	// Size caps the number of tokens and of epochs held in memory.
+	Size int
+	// FailOpen admits tokens that are not cached and cannot be checked while
+	// Redis is unreachable. Otherwise, they are refused.
+	FailOpen bool
+}
+
+// Revocations answers blacklist and token epoch lookups from memory, falling
+// back to Redis on a miss. Revoked tokens are remembered until they expire.
+// Tokens that are not revoked and epochs are only added to the cache while
+// subscribed to invalidations, which every revocation publishes, but are
+// served until their TTL runs out even if the subscription drops.
+type Revocations struct {
+	client *redis.Client
+	cfg    RevocationConfig
+
+	mu         sync.Mutex
+	tokens     map[string]revocationEntry
+	epochs     map[int64]epochEntry
+	subscribed bool
+	// generation is bumped by every invalidation, so a lookup that raced
+	// one does not cache the value it read before it.
+	generation uint64
+}
+
+type revocationEntry struct {
+	revoked bool
+	until   time.Time
+}
+
+type epochEntry struct {
+	epoch int64
+	until time.Time
+}
+
+// NewRevocations creates a local revocation cache. Run must be started for
+// it to cache anything but revoked tokens.
+func NewRevocations(c *redis.Client, cfg RevocationConfig) *Revocations {
+	return &Revocations{
+		client: c,
+		cfg:    cfg,
+		tokens: make(map[string]revocationEntry),
+		epochs: make(map[int64]epochEntry),
+	}
+}
+
+// FailOpen reports whether tokens that cannot be checked should be admitted.
+func (v *Revocations) FailOpen() bool {
+	return v.cfg.FailOpen
+}
+
+// Run subscribes to invalidations and applies them until ctx is done. While
+// the subscription is down, entries already cached keep being served until
+// their TTL runs out, so a short Redis outage does not fail every request,
+// but nothing new is cached. Once it is back, everything an invalidation
+// missed in the meantime could have changed is forgotten.
+func (v *Revocations) Run(ctx context.Context) {
+	sub := v.client.Subscribe(ctx, revocationChannel)
+	defer sub.Close()
+
+	for {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			v.mu.Lock()
+			v.subscribed = false
+			// Lookups in flight must not cache what they read
+			v.generation++
+			v.mu.Unlock()
+			revocationMetrics.Add("disconnects", 1)
+			// Receive reconnects on the next call
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				v.mu.Lock()
+				v.forget()
+				v.subscribed = true
+				v.mu.Unlock()
+			}
+		case *redis.Message:
+			v.invalidate(msg.Payload)
+		}
+	}
+}
+
+// IsBlacklisted reports whether the token has been revoked, from memory
+// when possible. expiresAt is when the token expires on its own.
+func (v *Revocations) IsBlacklisted(
+	ctx context.Context,
+	jti string,
+	expiresAt time.Time,
+) (bool, error) {
+	now := time.Now()
+
+	v.mu.Lock()
+	entry, ok := v.tokens[jti]
+	generation := v.generation
+	v.mu.Unlock()
+	if ok && now.Before(entry.until) {
+		revocationMetrics.Add("hits", 1)
+		return entry.revoked, nil
+	}
+	revocationMetrics.Add("misses", 1)
+
+	revoked, err := IsBlacklisted(ctx, v.client, jti)
+	if err != nil {
+		revocationMetrics.Add("errors", 1)
+		if v.cfg.FailOpen {
+			revocationMetrics.Add("failed_open", 1)
+		}
+		return false, err
+	}
+
+	until := now.Add(v.cfg.TTL)
+	if !expiresAt.IsZero() && (revoked || expiresAt.Before(until)) {
+		until = expiresAt
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !revoked && (!v.subscribed || generation != v.generation) {
+		return false, nil
+	}
+	if v.cfg.Size <= 0 {
+		return revoked, nil
+	}
+	if len(v.tokens) >= v.cfg.Size {
+		for key, entry := range v.tokens {
+			if !now.Before(entry.until) || len(v.tokens) >= v.cfg.Size {
+				delete(v.tokens, key)
+			}
+		}
+	}
+	v.tokens[jti] = revocationEntry{revoked: revoked, until: until}
+	return revoked, nil
+}
+
+// GetTokenEpoch returns the user's cached token epoch, from memory when it
+// was read recently. Errors from Redis, including ErrEpochNotCached, are
+// returned unchanged.
+func (v *Revocations) GetTokenEpoch(ctx context.Context, userID int64) (int64, error) {
+	now := time.Now()
+
+	v.mu.Lock()
+	entry, ok := v.epochs[userID]
+	generation := v.generation
+	v.mu.Unlock()
+	if ok && now.Before(entry.until) {
+		revocationMetrics.Add("epoch_hits", 1)
+		return entry.epoch, nil
+	}
+	revocationMetrics.Add("epoch_misses", 1)
+
+	epoch, err := GetTokenEpoch(ctx, v.client, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.subscribed || generation != v.generation || v.cfg.Size <= 0 {
+		return epoch, nil
+	}
+	if len(v.epochs) >= v.cfg.Size {
+		for key, entry := range v.epochs {
+			if !now.Before(entry.until) || len(v.epochs) >= v.cfg.Size {
+				delete(v.epochs, key)
+			}
+		}
+	}
+	v.epochs[userID] = epochEntry{epoch: epoch, until: now.Add(v.cfg.TTL)}
+	return epoch, nil
+}
+
+// invalidate applies one invalidation message.
+func (v *Revocations) invalidate(payload string) {
+	revocationMetrics.Add("invalidations", 1)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.generation++
+
+	kind, key, _ := strings.Cut(payload, ":")
+	switch kind {
+	case "token":
+		// A revoked token stays revoked
+		if entry, ok := v.tokens[key]; ok && !entry.revoked {
+			delete(v.tokens, key)
+		}
+	case "epoch":
+		if userID, err := strconv.ParseInt(key, 10, 64); err == nil {
+			delete(v.epochs, userID)
+		}
+	}
+}
+
+// forget drops everything an invalidation could have changed. The caller
+// must hold v.mu.
+func (v *Revocations) forget() {
+	v.generation++
+	for key, entry := range v.tokens {
+		if !entry.revoked {
+			delete(v.tokens, key)
+		}
+	}
+	clear(v.epochs)
+}
+
+// publishRevocation queues an invalidation telling every instance the token
+// has been revoked.
+func publishRevocation(ctx context.Context, pipe redis.Pipeliner, jti string) {
+	pipe.Publish(ctx, revocationChannel, fmt.Sprintf("token:%s", jti))
+}
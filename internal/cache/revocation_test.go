@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// duringGet runs a function once the next Redis GET has been answered, but
+// before the caller sees the answer, as if it raced the lookup.
+type duringGet struct {
+	f func()
+}
+
+func (h *duringGet) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *duringGet) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if f := h.f; f != nil && cmd.Name() == "get" {
+			h.f = nil
+			f()
+		}
+		return err
+	}
+}
+
+func (h *duringGet) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// startRevocations runs a revocation cache and waits until it is subscribed.
+func startRevocations(t *testing.T, c *redis.Client) *Revocations {
+	t.Helper()
+
+	v := NewRevocations(c, RevocationConfig{TTL: time.Hour, Size: 100})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go v.Run(ctx)
+
+	waitFor(t, "subscription", func() bool { return isSubscribed(v) })
+	return v
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !done(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// revokeSilently revokes a token without publishing an invalidation, as if
+// the message had been lost. Only a lookup that reaches Redis sees it.
+func revokeSilently(t *testing.T, c *redis.Client, jti string) {
+	t.Helper()
+
+	if err := c.Set(context.Background(), "blacklist:"+jti, "1", time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevocationsCacheGeneration(t *testing.T) {
+	tests := []struct {
+		name string
+		// during runs while the first lookup waits on Redis
+		during func(t *testing.T, v *Revocations, m *miniredis.Miniredis)
+		// cached is whether the first lookup's "not revoked" is remembered
+		cached bool
+	}{
+		{
+			name:   "no invalidation",
+			during: func(*testing.T, *Revocations, *miniredis.Miniredis) {},
+			cached: true,
+		},
+		{
+			name: "invalidation of the token",
+			during: func(_ *testing.T, v *Revocations, _ *miniredis.Miniredis) {
+				v.invalidate("token:jti")
+			},
+		},
+		{
+			name: "invalidation of another token",
+			during: func(_ *testing.T, v *Revocations, _ *miniredis.Miniredis) {
+				v.invalidate("token:other")
+			},
+		},
+		{
+			name: "invalidation of an epoch",
+			during: func(_ *testing.T, v *Revocations, _ *miniredis.Miniredis) {
+				v.invalidate("epoch:1")
+			},
+		},
+		{
+			name: "published revocation",
+			during: func(t *testing.T, v *Revocations, m *miniredis.Miniredis) {
+				before := currentGeneration(v)
+				m.Publish(revocationChannel, "token:other")
+				waitFor(t, "invalidation", func() bool { return currentGeneration(v) != before })
+			},
+		},
+		{
+			name: "resubscription",
+			during: func(_ *testing.T, v *Revocations, _ *miniredis.Miniredis) {
+				v.mu.Lock()
+				v.forget()
+				v.mu.Unlock()
+			},
+		},
+		{
+			name: "lost subscription",
+			during: func(_ *testing.T, v *Revocations, _ *miniredis.Miniredis) {
+				v.mu.Lock()
+				v.subscribed = false
+				v.mu.Unlock()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, c := newRedis(t)
+			hook := &duringGet{}
+			c.AddHook(hook)
+			v := startRevocations(t, c)
+			ctx := context.Background()
+
+			hook.f = func() { tt.during(t, v, m) }
+			revoked, err := v.IsBlacklisted(ctx, "jti", time.Time{})
+			if err != nil || revoked {
+				t.Fatalf("got revoked %t and error %v, want false", revoked, err)
+			}
+
+			revokeSilently(t, c, "jti")
+			revoked, err = v.IsBlacklisted(ctx, "jti", time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only a lookup that was not cached sees the silent revocation
+			if want := !tt.cached; revoked != want {
+				t.Errorf("got revoked %t after a silent revocation, want %t", revoked, want)
+			}
+		})
+	}
+}
+
+func currentGeneration(v *Revocations) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.generation
+}
+
+func TestRevocationsEpochGeneration(t *testing.T) {
+	_, c := newRedis(t)
+	hook := &duringGet{}
+	c.AddHook(hook)
+	v := startRevocations(t, c)
+	ctx := context.Background()
+
+	if err := SetTokenEpoch(ctx, c, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "epoch invalidation", func() bool { return currentGeneration(v) > 1 })
+
+	// A bump published while the old epoch was being read
+	hook.f = func() { v.invalidate("epoch:1") }
+	if epoch, err := v.GetTokenEpoch(ctx, 1); err != nil || epoch != 1 {
+		t.Fatalf("got epoch %d and error %v, want 1", epoch, err)
+	}
+	if err := c.Set(ctx, "token_epoch:1", 2, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if epoch, err := v.GetTokenEpoch(ctx, 1); err != nil || epoch != 2 {
+		t.Errorf("got epoch %d and error %v, want the raced read not cached", epoch, err)
+	}
+
+	// Without a race, the epoch is served from memory
+	if err := c.Set(ctx, "token_epoch:1", 3, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if epoch, err := v.GetTokenEpoch(ctx, 1); err != nil || epoch != 2 {
+		t.Errorf("got epoch %d and error %v, want the cached 2", epoch, err)
+	}
+}
+
+func TestRevocationsDisconnect(t *testing.T) {
+	m := miniredis.RunT(t)
+	// Lookups that reach Redis while it is down fail without retrying
+	c := redis.NewClient(&redis.Options{Addr: m.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { c.Close() })
+	v := startRevocations(t, c)
+	ctx := context.Background()
+
+	if err := Blacklist(ctx, c, "revoked", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTokenEpoch(ctx, c, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "epoch invalidation", func() bool { return currentGeneration(v) > 1 })
+	for jti, want := range map[string]bool{"revoked": true, "live": false, "stale": false} {
+		if revoked, err := v.IsBlacklisted(ctx, jti, time.Time{}); err != nil || revoked != want {
+			t.Fatalf("%s: got revoked %t and error %v, want %t", jti, revoked, err, want)
+		}
+	}
+	if epoch, err := v.GetTokenEpoch(ctx, 1); err != nil || epoch != 1 {
+		t.Fatalf("got epoch %d and error %v, want 1", epoch, err)
+	}
+
+	m.Close()
+	waitFor(t, "disconnect", func() bool { return !isSubscribed(v) })
+	v.mu.Lock()
+	v.tokens["stale"] = revocationEntry{until: time.Now().Add(-time.Second)}
+	v.mu.Unlock()
+
+	// What was cached is served until its TTL runs out, so a short outage
+	// does not refuse every request
+	for jti, want := range map[string]bool{"revoked": true, "live": false} {
+		if revoked, err := v.IsBlacklisted(ctx, jti, time.Time{}); err != nil || revoked != want {
+			t.Errorf("%s: got revoked %t and error %v while disconnected, want %t",
+				jti, revoked, err, want)
+		}
+	}
+	if epoch, err := v.GetTokenEpoch(ctx, 1); err != nil || epoch != 1 {
+		t.Errorf("got epoch %d and error %v while disconnected, want 1", epoch, err)
+	}
+	for _, jti := range []string{"stale", "unknown"} {
+		if _, err := v.IsBlacklisted(ctx, jti, time.Time{}); err == nil {
+			t.Errorf("%s: got no error for a token not cached while disconnected", jti)
+		}
+	}
+
+	// Invalidations published while the subscription was down were missed, so
+	// only revoked tokens outlast it
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "resubscription", func() bool { return isSubscribed(v) })
+	v.mu.Lock()
+	_, live := v.tokens["live"]
+	_, revoked := v.tokens["revoked"]
+	epochs := len(v.epochs)
+	v.mu.Unlock()
+	if live || epochs != 0 {
+		t.Errorf("got live token cached %t and %d epochs after resubscribing, want none",
+			live, epochs)
+	}
+	if !revoked {
+		t.Error("a revoked token was forgotten after resubscribing")
+	}
+}
+
+func isSubscribed(v *Revocations) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.subscribed
+}
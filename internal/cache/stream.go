@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"citadel/internal/auth"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrTicketNotFound is returned when a stream ticket is unknown, expired or
+// has already been used.
+var ErrTicketNotFound = errors.New("stream ticket not found or expired")
+
+// SaveStreamTicket binds a stream ticket to the claims of the user who
+// requested it.
+func SaveStreamTicket(
+	ctx context.Context,
+	c *redis.Client,
+	ticket string,
+	claims *auth.Claims,
+	ttl time.Duration,
+) error {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, fmt.Sprintf("stream_ticket:%s", ticket), data, ttl).Err()
+}
+
+// ConsumeStreamTicket returns and deletes a ticket's claims so each ticket
+// opens at most one stream.
+func ConsumeStreamTicket(
+	ctx context.Context,
+	c *redis.Client,
+	ticket string,
+) (*auth.Claims, error) {
+	data, err := c.GetDel(ctx, fmt.Sprintf("stream_ticket:%s", ticket)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var claims auth.Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, fmt.Errorf("invalid stream ticket data: %w", err)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ThrottlePolicy describes how failed attempts against one key are slowed down
+// and eventually locked out. Failures are counted in a sliding window.
+type ThrottlePolicy struct {
+	Window          time.Duration
+	DelayAfter      int64
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int64
+	LockoutDuration time.Duration
+}
+
+// ThrottleStatus is the current state of a throttled key.
+type ThrottleStatus struct {
+	Failures   int64
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// CheckThrottle reports whether another attempt is allowed for the key. A
+// non-zero RetryAfter means the caller must wait, either for the progressive
+// delay after recent failures or for a lockout to expire.
+func CheckThrottle(
+	ctx context.Context,
+	c *redis.Client,
+	policy ThrottlePolicy,
+	key string,
+) (*ThrottleStatus, error) {
+	failuresKey := fmt.Sprintf("throttle_failures:%s", key)
+	lockoutKey := fmt.Sprintf("throttle_lockout:%s", key)
+	now := time.Now()
+
+	pipe := c.Pipeline()
+	lockTTL := pipe.PTTL(ctx, lockoutKey)
+	count := pipe.ZCount(ctx, failuresKey, windowStart(now, policy), "+inf")
+	last := pipe.ZRangeWithScores(ctx, failuresKey, -1, -1)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	status := &ThrottleStatus{Failures: count.Val()}
+	if ttl := lockTTL.Val(); ttl > 0 {
+		status.Locked = true
+		status.RetryAfter = ttl
+		return status, nil
+	}
+
+	if status.Failures >= policy.DelayAfter && len(last.Val()) == 1 {
+		delay := policy.BaseDelay << min(status.Failures-policy.DelayAfter, 30)
+		if delay > policy.MaxDelay || delay <= 0 {
+			delay = policy.MaxDelay
+		}
+		lastFailure := time.UnixMilli(int64(last.Val()[0].Score))
+		if wait := lastFailure.Add(delay).Sub(now); wait > 0 {
+			status.RetryAfter = wait
+		}
+	}
+
+	return status, nil
+}
+
+// RecordFailure counts a failed attempt against the key. Returns true when this
+// failure tipped the key into a lockout.
+func RecordFailure(
+	ctx context.Context,
+	c *redis.Client,
+	policy ThrottlePolicy,
+	key string,
+) (bool, error) {
+	failuresKey := fmt.Sprintf("throttle_failures:%s", key)
+	lockoutKey := fmt.Sprintf("throttle_lockout:%s", key)
+	now := time.Now()
+
+	pipe := c.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, failuresKey, "-inf", "("+windowStart(now, policy))
+	pipe.ZAdd(ctx, failuresKey, redis.Z{
+		Score:  float64(now.UnixMilli()),
+		Member: uuid.New().String(),
+	})
+	count := pipe.ZCard(ctx, failuresKey)
+	pipe.Expire(ctx, failuresKey, policy.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	if count.Val() < policy.LockoutAfter {
+		return false, nil
+	}
+
+	locked, err := c.SetNX(ctx, lockoutKey, now.Unix(), policy.LockoutDuration).Result()
+	if err != nil {
+		return false, err
+	}
+	if locked {
+		// Start from a clean slate once the lockout expires
+		if err := c.Del(ctx, failuresKey).Err(); err != nil {
+			return true, err
+		}
+	}
+	return locked, nil
+}
+
+// ResetThrottle forgets the failures and any lockout for the key. Returns true
+// if the key was locked out.
+func ResetThrottle(ctx context.Context, c *redis.Client, key string) (bool, error) {
+	failuresKey := fmt.Sprintf("throttle_failures:%s", key)
+	lockoutKey := fmt.Sprintf("throttle_lockout:%s", key)
+
+	pipe := c.TxPipeline()
+	pipe.Del(ctx, failuresKey)
+	unlocked := pipe.Del(ctx, lockoutKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return unlocked.Val() > 0, nil
+}
+
+func windowStart(now time.Time, policy ThrottlePolicy) string {
+	return strconv.FormatInt(now.Add(-policy.Window).UnixMilli(), 10)
+}
+
+// countRequest counts a request against the key and returns the number made in
+// the current window and how long it has left.
+func countRequest(
+	ctx context.Context,
+	c *redis.Client,
+	key string,
+	window time.Duration,
+) (int64, time.Duration, error) {
+	pipe := c.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	// Only the first request in a window starts the clock
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.TTL(ctx, key)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	return incr.Val(), ttl.Val(), nil
+}
+
+// normalizeEmail keys per-address limits the same however the address is typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
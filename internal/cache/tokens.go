@@ -2,15 +2,63 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrRefreshNotFound is returned when a refresh token is unknown or expired.
+var ErrRefreshNotFound = errors.New("refresh token not found or expired")
+
+// ErrRefreshReused is returned when a refresh token that was already rotated
+// out of its family is presented again.
+var ErrRefreshReused = errors.New("refresh token reused")
+
+// ErrSessionNotFound is returned when a session does not exist or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Refresh is what a refresh token resolves to.
+type Refresh struct {
+	UserID   int64
+	FamilyID string
+	// Scope is the scope the session was narrowed to at sign-in, or empty
+	// when it was granted everything the user holds.
+	Scope string
+}
+
+// Device identifies the client a refresh token was issued to or used from.
+type Device struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is a signed-in device. Each token family is one session, so the
+// session survives refresh token rotation.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// Blacklist revokes an access token until it expires and tells every
+// instance to drop it from its local revocation cache.
 func Blacklist(ctx context.Context, c *redis.Client, jti string, ttl time.Duration) error {
 	key := fmt.Sprintf("blacklist:%s", jti)
-	return c.Set(ctx, key, "1", ttl).Err()
+
+	pipe := c.TxPipeline()
+	pipe.Set(ctx, key, "1", ttl)
+	publishRevocation(ctx, pipe, jti)
+
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
 func IsBlacklisted(ctx context.Context, c *redis.Client, jti string) (bool, error) {
@@ -25,68 +73,304 @@ func IsBlacklisted(ctx context.Context, c *redis.Client, jti string) (bool, erro
 	return val == "1", nil
 }
 
-func StoreRefresh(
+// StartFamily stores the first refresh token of a new token family and returns
+// the family ID. Every login starts a family; each rotation retires the previous
+// token but keeps it mapped to the family so a replay can be detected. The scope
+// the session was narrowed to, if any, is kept for every rotation.
+func StartFamily(
 	ctx context.Context,
 	c *redis.Client,
 	tokenID string,
 	userID int64,
+	scope string,
+	device Device,
 	ttl time.Duration,
-) error {
+) (string, error) {
+	familyID := uuid.New().String()
 	key := fmt.Sprintf("refresh:%s", tokenID)
+	familyKey := fmt.Sprintf("family:%s", familyID)
 	userKey := fmt.Sprintf("user_tokens:%d", userID)
 
-	pipe := c.Pipeline()
-	pipe.Set(ctx, key, userID, ttl)
-	pipe.SAdd(ctx, userKey, tokenID)
+	pipe := c.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "family_id", familyID)
+	pipe.Expire(ctx, key, ttl)
+	now := time.Now().Unix()
+	pipe.HSet(ctx, familyKey,
+		"user_id", userID,
+		"current", tokenID,
+		"created_at", now,
+		"last_used", now,
+		"ip", device.IP,
+		"user_agent", device.UserAgent,
+		"scope", scope,
+	)
+	pipe.Expire(ctx, familyKey, ttl)
+	pipe.SAdd(ctx, userKey, familyID)
 	pipe.Expire(ctx, userKey, ttl)
 
-	_, err := pipe.Exec(ctx)
-	return err
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return familyID, nil
 }
 
-func GetRefresh(ctx context.Context, c *redis.Client, tokenID string) (int64, error) {
-	key := fmt.Sprintf("refresh:%s", tokenID)
-	val, err := c.Get(ctx, key).Int64()
+// rotateScript atomically swaps the current refresh token of a family for a new
+// one, retires the old token and records where it was used from. A retired
+// token is reported as reused.
+var rotateScript = redis.NewScript(`
+local uid = redis.call('HGET', KEYS[1], 'user_id')
+if not uid then
+	local fid = redis.call('GET', KEYS[2])
+	if fid then
+		return {'reused', fid}
+	end
+	return false
+end
+local fid = redis.call('HGET', KEYS[1], 'family_id')
+redis.call('DEL', KEYS[1])
+redis.call('SET', KEYS[2], fid, 'EX', ARGV[2])
+redis.call('HSET', KEYS[3], 'user_id', uid, 'family_id', fid)
+redis.call('EXPIRE', KEYS[3], ARGV[2])
+local familyKey = 'family:' .. fid
+redis.call('HSET', familyKey,
+	'current', ARGV[1],
+	'last_used', ARGV[3],
+	'ip', ARGV[4],
+	'user_agent', ARGV[5])
+redis.call('EXPIRE', familyKey, ARGV[2])
+redis.call('EXPIRE', 'user_tokens:' .. uid, ARGV[2])
+local scope = redis.call('HGET', familyKey, 'scope') or ''
+return {'rotated', fid, uid, scope}
+`)
+
+// RotateRefresh exchanges a refresh token for a new one in the same family.
+// Returns ErrRefreshReused along with the family when the old token had already
+// been rotated out, so the caller can revoke the whole family.
+func RotateRefresh(
+	ctx context.Context,
+	c *redis.Client,
+	oldTokenID string,
+	newTokenID string,
+	device Device,
+	ttl time.Duration,
+) (*Refresh, error) {
+	keys := []string{
+		fmt.Sprintf("refresh:%s", oldTokenID),
+		fmt.Sprintf("family_retired:%s", oldTokenID),
+		fmt.Sprintf("refresh:%s", newTokenID),
+	}
+	res, err := rotateScript.Run(
+		ctx,
+		c,
+		keys,
+		newTokenID,
+		int64(ttl.Seconds()),
+		time.Now().Unix(),
+		device.IP,
+		device.UserAgent,
+	).StringSlice()
 	if err == redis.Nil {
-		return 0, fmt.Errorf("refresh token not found or expired")
+		return nil, ErrRefreshNotFound
 	}
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if res[0] == "reused" {
+		return &Refresh{FamilyID: res[1]}, ErrRefreshReused
+	}
+
+	userID, err := strconv.ParseInt(res[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id in refresh token: %w", err)
 	}
-	return val, nil
+	return &Refresh{UserID: userID, FamilyID: res[1], Scope: res[3]}, nil
 }
 
-func DeleteRefresh(ctx context.Context, c *redis.Client, tokenID string, userID int64) error {
+// LookupRefresh resolves a live refresh token without rotating it and
+// returns when it expires.
+func LookupRefresh(
+	ctx context.Context,
+	c *redis.Client,
+	tokenID string,
+) (*Refresh, time.Time, error) {
 	key := fmt.Sprintf("refresh:%s", tokenID)
-	userKey := fmt.Sprintf("user_tokens:%d", userID)
 
 	pipe := c.Pipeline()
-	pipe.Del(ctx, key)
-	pipe.SRem(ctx, userKey, tokenID)
+	fields := pipe.HGetAll(ctx, key)
+	ttl := pipe.TTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, time.Time{}, err
+	}
 
-	_, err := pipe.Exec(ctx)
+	refresh := fields.Val()
+	if len(refresh) == 0 {
+		return nil, time.Time{}, ErrRefreshNotFound
+	}
+	userID, err := strconv.ParseInt(refresh["user_id"], 10, 64)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid user id in refresh token: %w", err)
+	}
+	expiresAt := time.Now().Add(ttl.Val())
+	return &Refresh{UserID: userID, FamilyID: refresh["family_id"]}, expiresAt, nil
+}
+
+// TrackAccess records an access token issued from a family so revoking the
+// family can blacklist it until it expires.
+func TrackAccess(
+	ctx context.Context,
+	c *redis.Client,
+	familyID string,
+	jti string,
+	expiresAt time.Time,
+) error {
+	accessKey := fmt.Sprintf("family_access:%s", familyID)
+	familyKey := fmt.Sprintf("family:%s", familyID)
+
+	ttl, err := c.TTL(ctx, familyKey).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := c.Pipeline()
+	// Drop entries for access tokens that have already expired on their own
+	pipe.ZRemRangeByScore(ctx, accessKey, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+	pipe.ZAdd(ctx, accessKey, redis.Z{Score: float64(expiresAt.Unix()), Member: jti})
+	if ttl > 0 {
+		pipe.Expire(ctx, accessKey, ttl)
+	}
+
+	_, err = pipe.Exec(ctx)
 	return err
 }
 
-func DeleteUserRefresh(ctx context.Context, c *redis.Client, userID int64) error {
+// RevokeFamily deletes a family's live refresh token and blacklists every
+// access token issued from it that has not yet expired. Returns the owning
+// user, or zero if the family no longer exists.
+func RevokeFamily(ctx context.Context, c *redis.Client, familyID string) (int64, error) {
+	familyKey := fmt.Sprintf("family:%s", familyID)
+	accessKey := fmt.Sprintf("family_access:%s", familyID)
+
+	family, err := c.HGetAll(ctx, familyKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	jtis, err := c.ZRangeByScoreWithScores(ctx, accessKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(now.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := c.TxPipeline()
+	for _, z := range jtis {
+		ttl := time.Unix(int64(z.Score), 0).Sub(now)
+		if ttl > 0 {
+			pipe.Set(ctx, fmt.Sprintf("blacklist:%s", z.Member), "1", ttl)
+			publishRevocation(ctx, pipe, z.Member.(string))
+		}
+	}
+	var userID int64
+	if len(family) > 0 {
+		userID, _ = strconv.ParseInt(family["user_id"], 10, 64)
+		pipe.Del(ctx, fmt.Sprintf("refresh:%s", family["current"]))
+		pipe.SRem(ctx, fmt.Sprintf("user_tokens:%d", userID), familyID)
+	}
+	pipe.Del(ctx, familyKey, accessKey)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// GetSession returns a single session.
+func GetSession(ctx context.Context, c *redis.Client, familyID string) (*Session, error) {
+	family, err := c.HGetAll(ctx, fmt.Sprintf("family:%s", familyID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(family) == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return parseSession(familyID, family), nil
+}
+
+// ListSessions returns the user's live sessions, most recently used first.
+func ListSessions(ctx context.Context, c *redis.Client, userID int64) ([]Session, error) {
 	userKey := fmt.Sprintf("user_tokens:%d", userID)
 
-	tokenIDs, err := c.SMembers(ctx, userKey).Result()
+	familyIDs, err := c.SMembers(ctx, userKey).Result()
 	if err != nil && err != redis.Nil {
-		return err
+		return nil, err
 	}
 
-	if len(tokenIDs) == 0 {
-		return nil
+	pipe := c.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(familyIDs))
+	for i, familyID := range familyIDs {
+		cmds[i] = pipe.HGetAll(ctx, fmt.Sprintf("family:%s", familyID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
 	}
 
-	pipe := c.Pipeline()
-	for _, tokenID := range tokenIDs {
-		key := fmt.Sprintf("refresh:%s", tokenID)
-		pipe.Del(ctx, key)
+	sessions := []Session{}
+	var expired []any
+	for i, cmd := range cmds {
+		family, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+		// Families expire on their own; prune the dangling set members
+		if len(family) == 0 {
+			expired = append(expired, familyIDs[i])
+			continue
+		}
+		sessions = append(sessions, *parseSession(familyIDs[i], family))
+	}
+	if len(expired) > 0 {
+		if err := c.SRem(ctx, userKey, expired...).Err(); err != nil {
+			return nil, err
+		}
 	}
-	pipe.Del(ctx, userKey)
 
-	_, err = pipe.Exec(ctx)
-	return err
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed.After(sessions[j].LastUsed)
+	})
+	return sessions, nil
+}
+
+func parseSession(familyID string, family map[string]string) *Session {
+	userID, _ := strconv.ParseInt(family["user_id"], 10, 64)
+	createdAt, _ := strconv.ParseInt(family["created_at"], 10, 64)
+	lastUsed, _ := strconv.ParseInt(family["last_used"], 10, 64)
+	return &Session{
+		ID:        familyID,
+		UserID:    userID,
+		CreatedAt: time.Unix(createdAt, 0).UTC(),
+		LastUsed:  time.Unix(lastUsed, 0).UTC(),
+		IP:        family["ip"],
+		UserAgent: family["user_agent"],
+	}
+}
+
+// DeleteUserRefresh revokes every token family belonging to the user.
+func DeleteUserRefresh(ctx context.Context, c *redis.Client, userID int64) error {
+	userKey := fmt.Sprintf("user_tokens:%d", userID)
+
+	familyIDs, err := c.SMembers(ctx, userKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	for _, familyID := range familyIDs {
+		if _, err := RevokeFamily(ctx, c, familyID); err != nil {
+			return err
+		}
+	}
+
+	return c.Del(ctx, userKey).Err()
 }
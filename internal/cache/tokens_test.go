@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { c.Close() })
+	return m, c
+}
+
+// family is a token family started with the refresh token "t1".
+type family struct {
+	m  *miniredis.Miniredis
+	c  *redis.Client
+	id string
+}
+
+func TestRotateRefresh(t *testing.T) {
+	const userID = 7
+
+	tests := []struct {
+		name string
+		// setup returns the refresh token to rotate
+		setup   func(t *testing.T, f family) string
+		wantErr error
+	}{
+		{
+			name: "current token",
+			setup: func(t *testing.T, f family) string {
+				return "t1"
+			},
+		},
+		{
+			name: "current token after a rotation",
+			setup: func(t *testing.T, f family) string {
+				mustRotate(t, f.c, "t1", "t2")
+				return "t2"
+			},
+		},
+		{
+			name: "rotated out token",
+			setup: func(t *testing.T, f family) string {
+				mustRotate(t, f.c, "t1", "t2")
+				return "t1"
+			},
+			wantErr: ErrRefreshReused,
+		},
+		{
+			name: "token rotated out long ago",
+			setup: func(t *testing.T, f family) string {
+				mustRotate(t, f.c, "t1", "t2")
+				mustRotate(t, f.c, "t2", "t3")
+				return "t1"
+			},
+			wantErr: ErrRefreshReused,
+		},
+		{
+			name: "rotated out token of a revoked family",
+			setup: func(t *testing.T, f family) string {
+				mustRotate(t, f.c, "t1", "t2")
+				if _, err := RevokeFamily(context.Background(), f.c, f.id); err != nil {
+					t.Fatal(err)
+				}
+				return "t1"
+			},
+			wantErr: ErrRefreshReused,
+		},
+		{
+			name: "current token of a revoked family",
+			setup: func(t *testing.T, f family) string {
+				if _, err := RevokeFamily(context.Background(), f.c, f.id); err != nil {
+					t.Fatal(err)
+				}
+				return "t1"
+			},
+			wantErr: ErrRefreshNotFound,
+		},
+		{
+			name: "unknown token",
+			setup: func(t *testing.T, f family) string {
+				return "unknown"
+			},
+			wantErr: ErrRefreshNotFound,
+		},
+		{
+			name: "expired token",
+			setup: func(t *testing.T, f family) string {
+				f.m.FastForward(2 * time.Hour)
+				return "t1"
+			},
+			wantErr: ErrRefreshNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, c := newRedis(t)
+			ctx := context.Background()
+
+			familyID, err := StartFamily(ctx, c, "t1", userID, "account", Device{}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := tt.setup(t, family{m: m, c: c, id: familyID})
+
+			refresh, err := RotateRefresh(ctx, c, token, "next", Device{}, time.Hour)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			switch {
+			case tt.wantErr == nil:
+				want := Refresh{UserID: userID, FamilyID: familyID, Scope: "account"}
+				if *refresh != want {
+					t.Errorf("got %+v, want %+v", *refresh, want)
+				}
+			case errors.Is(err, ErrRefreshReused):
+				// The caller revokes the family the replayed token came from
+				if refresh == nil || refresh.FamilyID != familyID {
+					t.Errorf("got %+v, want family %s", refresh, familyID)
+				}
+			}
+		})
+	}
+}
+
+func mustRotate(t *testing.T, c *redis.Client, from, to string) {
+	t.Helper()
+
+	if _, err := RotateRefresh(context.Background(), c, from, to, Device{}, time.Hour); err != nil {
+		t.Fatalf("failed to rotate %s to %s: %v", from, to, err)
+	}
+}
+
+func TestRevokeFamily(t *testing.T) {
+	_, c := newRedis(t)
+	ctx := context.Background()
+
+	familyID, err := StartFamily(ctx, c, "t1", 7, "", Device{}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := StartFamily(ctx, c, "o1", 7, "", Device{}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for jti, expiresAt := range map[string]time.Time{
+		"live":    now.Add(5 * time.Minute),
+		"expired": now.Add(-time.Minute),
+	} {
+		if err := TrackAccess(ctx, c, familyID, jti, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := TrackAccess(ctx, c, other, "other", now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := RevokeFamily(ctx, c, familyID)
+	if err != nil || userID != 7 {
+		t.Fatalf("got user %d and error %v, want user 7", userID, err)
+	}
+
+	for jti, want := range map[string]bool{"live": true, "expired": false, "other": false} {
+		got, err := IsBlacklisted(ctx, c, jti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("access token %s: got blacklisted %t, want %t", jti, got, want)
+		}
+	}
+	if _, err := RotateRefresh(ctx, c, "o1", "o2", Device{}, time.Hour); err != nil {
+		t.Errorf("got error %v for another family, want it untouched", err)
+	}
+
+	// Revoking again finds nothing
+	if userID, err := RevokeFamily(ctx, c, familyID); err != nil || userID != 0 {
+		t.Errorf("got user %d and error %v revoking again, want user 0", userID, err)
+	}
+}
@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CountVerificationRequest counts a request to resend the verification email
+// to the address and returns the number made in the current window and how
+// long it has left.
+func CountVerificationRequest(
+	ctx context.Context,
+	c *redis.Client,
+	email string,
+	window time.Duration,
+) (int64, time.Duration, error) {
+	return countRequest(ctx, c, "verification_requests:"+normalizeEmail(email), window)
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrCeremonyNotFound is returned when a WebAuthn ceremony is unknown,
+// expired or has already been finished.
+var ErrCeremonyNotFound = errors.New("webauthn ceremony not found or expired")
+
+// SaveCeremony holds the challenge of a WebAuthn registration or login
+// ceremony until the client answers it.
+func SaveCeremony(
+	ctx context.Context,
+	c *redis.Client,
+	id string,
+	session *webauthn.SessionData,
+	ttl time.Duration,
+) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, fmt.Sprintf("webauthn:%s", id), data, ttl).Err()
+}
+
+// ConsumeCeremony returns and deletes a ceremony so each challenge can be
+// answered at most once.
+func ConsumeCeremony(
+	ctx context.Context,
+	c *redis.Client,
+	id string,
+) (*webauthn.SessionData, error) {
+	data, err := c.GetDel(ctx, fmt.Sprintf("webauthn:%s", id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCeremonyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("invalid webauthn ceremony data: %w", err)
+	}
+	return &session, nil
+}
@@ -21,7 +21,7 @@ CREATE TABLE IF NOT EXISTS users (
 `
 
 func New(path string) (*sqlx.DB, error) {
-	db, err := sqlx.Open("sqlite3", path)
+	db, err := sqlx.Open("sqlite3", path+"?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open the database: %w", err)
 	}
@@ -34,5 +34,9 @@ func New(path string) (*sqlx.DB, error) {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrations are applied in order on top of schema. The number of applied
+// migrations is tracked in PRAGMA user_version so each one runs exactly once.
+// Never edit a migration that has shipped; append a new one instead.
+var migrations = []string{
+	// 1: roles and permissions
+	`
+CREATE TABLE IF NOT EXISTS roles (
+	role_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	permission_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+	permission_id INTEGER NOT NULL REFERENCES permissions(permission_id) ON DELETE CASCADE,
+	PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name, description) VALUES
+	('admin', 'Full administrative access'),
+	('user', 'Self-service access to your own account');
+
+INSERT INTO permissions (name, description) VALUES
+	('users:read', 'List and view all users'),
+	('users:write', 'Edit any user'),
+	('roles:manage', 'Grant and revoke roles'),
+	('logs:stream', 'Stream server logs');
+
+INSERT INTO role_permissions (role_id, permission_id)
+	SELECT r.role_id, p.permission_id FROM roles r, permissions p WHERE r.name = 'admin';
+
+INSERT INTO user_roles (user_id, role_id)
+	SELECT u.user_id, r.role_id FROM users u, roles r WHERE r.name = 'user';
+`,
+	// 2: TOTP two-factor authentication
+	`
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	confirmed_at DATETIME,
+	last_used_step INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+`,
+	// 3: email verification
+	`
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+`,
+	// 4: password resets
+	`
+CREATE TABLE IF NOT EXISTS password_resets (
+	reset_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user ON password_resets(user_id);
+`,
+	// 5: OAuth clients
+	`
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	secret_hash TEXT,
+	redirect_uris TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO permissions (name, description) VALUES
+	('clients:manage', 'Register and remove OAuth clients');
+
+INSERT INTO role_permissions (role_id, permission_id)
+	SELECT r.role_id, p.permission_id FROM roles r, permissions p
+	WHERE r.name = 'admin' AND p.name = 'clients:manage';
+`,
+	// 6: personal access tokens
+	`
+CREATE TABLE IF NOT EXISTS api_tokens (
+	token_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL DEFAULT '',
+	expires_at DATETIME,
+	last_used_at DATETIME,
+	last_used_ip TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);
+`,
+	// 7: WebAuthn passkeys
+	`
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	credential_id BLOB PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	public_key BLOB NOT NULL,
+	attestation_type TEXT NOT NULL DEFAULT '',
+	transports TEXT NOT NULL DEFAULT '',
+	flags INTEGER NOT NULL DEFAULT 0,
+	aaguid BLOB,
+	sign_count INTEGER NOT NULL DEFAULT 0,
+	clone_warning BOOLEAN NOT NULL DEFAULT 0,
+	last_used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+`,
+	// 8: token revocation epoch and account suspension
+	`
+ALTER TABLE users ADD COLUMN token_epoch INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN suspended_at DATETIME;
+`,
+	// 9: security audit log. User IDs are not foreign keys so events outlive
+	// the accounts they mention.
+	`
+CREATE TABLE IF NOT EXISTS audit_events (
+	event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_type TEXT NOT NULL,
+	actor_id INTEGER,
+	target_id INTEGER,
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	details TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events(target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events(event_type);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+INSERT INTO permissions (name, description) VALUES
+	('audit:read', 'Read the security audit log');
+
+INSERT INTO role_permissions (role_id, permission_id)
+	SELECT r.role_id, p.permission_id FROM roles r, permissions p
+	WHERE r.name = 'admin' AND p.name = 'audit:read';
+`,
+	// 10: admin impersonation
+	`
+ALTER TABLE audit_events ADD COLUMN impersonator_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_audit_events_impersonator ON audit_events(impersonator_id);
+
+INSERT INTO permissions (name, description) VALUES
+	('users:impersonate', 'Act as another user for support');
+
+INSERT INTO role_permissions (role_id, permission_id)
+	SELECT r.role_id, p.permission_id FROM roles r, permissions p
+	WHERE r.name = 'admin' AND p.name = 'users:impersonate';
+`,
+	// 11: account deletion. A deleted account keeps its row, with its username
+	// and email released, until it is purged after the grace period.
+	`
+ALTER TABLE users ADD COLUMN deleted_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS deleted_users (
+	user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+	username TEXT NOT NULL,
+	email TEXT NOT NULL,
+	purge_after DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_deleted_users_purge ON deleted_users(purge_after);
+`,
+	// 12: indexes for paging through users by sign-up and last login
+	`
+CREATE INDEX IF NOT EXISTS idx_users_created ON users(created_at, user_id);
+CREATE INDEX IF NOT EXISTS idx_users_last_login ON users(COALESCE(last_login, ''), user_id);
+`,
+	// 13: API tokens carry the token epoch they were created under, so signing
+	// out everywhere ends them too
+	`
+ALTER TABLE api_tokens ADD COLUMN token_epoch INTEGER NOT NULL DEFAULT 0;
+
+UPDATE api_tokens SET token_epoch = (
+	SELECT token_epoch FROM users WHERE users.user_id = api_tokens.user_id
+);
+`,
+}
+
+// migrate applies every migration newer than the database's user_version.
+func migrate(db *sqlx.DB) error {
+	var version int
+	if err := db.Get(&version, `PRAGMA user_version`); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Beginx()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+// Package export builds personal data archives: a zip of JSON files holding
+// everything stored about one user, described by a manifest.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/cache"
+	"citadel/internal/mfa"
+	"citadel/internal/passkey"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// FormatVersion is bumped whenever the layout of the archive changes.
+const FormatVersion = 1
+
+// auditPageSize is how many audit events are read at a time.
+const auditPageSize = 500
+
+// ManifestFile is the name of the manifest in the archive.
+const ManifestFile = "manifest.json"
+
+// Manifest describes an archive. It is written last, as manifest.json.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	UserID        int64     `json:"user_id"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	Files         []File    `json:"files"`
+}
+
+// File is one JSON file in the archive. Records is the number of entries for
+// files holding a list, or 1.
+type File struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// apiToken is how an API token is exported. Its hash is left out.
+type apiToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// passkeyCredential is how a passkey is exported. The public key is left
+// out, as it means nothing outside of citadel.
+type passkeyCredential struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Transports string     `json:"transports"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// mfaStatus is how MFA is exported. Secrets and code hashes are left out.
+type mfaStatus struct {
+	TOTPEnabled            bool       `json:"totp_enabled"`
+	TOTPEnrolledAt         *time.Time `json:"totp_enrolled_at,omitempty"`
+	TOTPConfirmedAt        *time.Time `json:"totp_confirmed_at,omitempty"`
+	RemainingRecoveryCodes int        `json:"remaining_recovery_codes"`
+}
+
+// Write builds the user's archive into w and returns its manifest. Secrets
+// such as password and token hashes are never exported.
+func Write(
+	ctx context.Context,
+	db *sqlx.DB,
+	rdb *redis.Client,
+	userID int64,
+	w io.Writer,
+) (*Manifest, error) {
+	u, err := user.ByID(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &archive{
+		zip: zip.NewWriter(w),
+		manifest: Manifest{
+			FormatVersion: FormatVersion,
+			UserID:        userID,
+			GeneratedAt:   time.Now().UTC().Truncate(time.Second),
+		},
+	}
+
+	if err := a.add("user.json", 1, u); err != nil {
+		return nil, err
+	}
+
+	roles, err := user.Roles(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.add("roles.json", len(roles), roles); err != nil {
+		return nil, err
+	}
+
+	sessions, err := cache.ListSessions(ctx, rdb, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if err := a.add("sessions.json", len(sessions), sessions); err != nil {
+		return nil, err
+	}
+
+	tokens, err := user.ListAPITokens(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	exportedTokens := make([]apiToken, len(tokens))
+	for i, t := range tokens {
+		exportedTokens[i] = apiToken{
+			ID:         t.TokenId,
+			Name:       t.Name,
+			Scopes:     t.ScopeList(),
+			ExpiresAt:  t.ExpiresAt,
+			LastUsedAt: t.LastUsedAt,
+			LastUsedIP: t.LastUsedIP.String,
+			CreatedAt:  t.CreatedAt,
+		}
+	}
+	if err := a.add("api_tokens.json", len(exportedTokens), exportedTokens); err != nil {
+		return nil, err
+	}
+
+	credentials, err := passkey.ByUser(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys := make([]passkeyCredential, len(credentials))
+	for i, c := range credentials {
+		passkeys[i] = passkeyCredential{
+			ID:         c.ID(),
+			Name:       c.Name,
+			Transports: c.Transports,
+			CreatedAt:  c.CreatedAt,
+			LastUsedAt: c.LastUsedAt,
+		}
+	}
+	if err := a.add("passkeys.json", len(passkeys), passkeys); err != nil {
+		return nil, err
+	}
+
+	var status mfaStatus
+	totp, err := mfa.ByUser(ctx, db, userID)
+	if err != nil && !errors.Is(err, mfa.ErrNotEnrolled) {
+		return nil, err
+	}
+	if totp != nil {
+		status.TOTPEnabled = totp.ConfirmedAt != nil
+		status.TOTPEnrolledAt = &totp.CreatedAt
+		status.TOTPConfirmedAt = totp.ConfirmedAt
+	}
+	status.RemainingRecoveryCodes, err = mfa.RemainingRecoveryCodes(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.add("mfa.json", 1, status); err != nil {
+		return nil, err
+	}
+
+	events, err := auditEvents(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.add("audit_events.json", len(events), events); err != nil {
+		return nil, err
+	}
+
+	if err := a.close(); err != nil {
+		return nil, err
+	}
+	return &a.manifest, nil
+}
+
+// auditEvents returns every audit event the user took part in, newest first.
+func auditEvents(ctx context.Context, db *sqlx.DB, userID int64) ([]audit.Event, error) {
+	events := []audit.Event{}
+	filter := audit.Filter{UserID: &userID, Limit: auditPageSize}
+	for {
+		page, err := audit.List(ctx, db, filter)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+		if len(page) < auditPageSize {
+			return events, nil
+		}
+		filter.Before = page[len(page)-1].ID
+	}
+}
+
+// archive writes JSON files to a zip and keeps track of them for the
+// manifest.
+type archive struct {
+	zip      *zip.Writer
+	manifest Manifest
+}
+
+func (a *archive) add(name string, records int, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	if err := a.write(name, data); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	a.manifest.Files = append(a.manifest.Files, File{
+		Name:    name,
+		Records: records,
+		SHA256:  hex.EncodeToString(sum[:]),
+	})
+	return nil
+}
+
+func (a *archive) write(name string, data []byte) error {
+	f, err := a.zip.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: a.manifest.GeneratedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// close writes the manifest and finishes the archive.
+func (a *archive) close() error {
+	data, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := a.write(ManifestFile, data); err != nil {
+		return err
+	}
+	if err := a.zip.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
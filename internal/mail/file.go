@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// File writes each message to a directory as an .eml file for local development.
+type File struct {
+	from   string
+	dir    string
+	logger *slog.Logger
+}
+
+// NewFile creates a file mailer, creating the directory if needed.
+func NewFile(from, dir string, logger *slog.Logger) (*File, error) {
+	if dir == "" {
+		dir = "./mail"
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &File{from: from, dir: dir, logger: logger}, nil
+}
+
+// Send implements Mailer.
+func (f *File) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), uuid.New().String())
+	path := filepath.Join(f.dir, name)
+	if err := os.WriteFile(path, msg.encode(f.from), 0o600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	f.logger.Info("mail written to file", "to", msg.To, "subject", msg.Subject, "path", path)
+	return nil
+}
@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Log writes messages to the application log for local development.
+// Message bodies contain live tokens, so never use it in production.
+type Log struct {
+	from   string
+	logger *slog.Logger
+}
+
+// NewLog creates a log mailer.
+func NewLog(from string, logger *slog.Logger) *Log {
+	return &Log{from: from, logger: logger}
+}
+
+// Send implements Mailer.
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	l.logger.Info("mail sent to log",
+		"from", l.from,
+		"to", msg.To,
+		"subject", msg.Subject,
+		"body", msg.Body,
+	)
+	return nil
+}
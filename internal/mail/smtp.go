@@ -0,0 +1,111 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// sendTimeout bounds a whole SMTP conversation, so a stuck relay cannot hold
+// up the request that sends the mail.
+const sendTimeout = 30 * time.Second
+
+// SMTP delivers mail through an SMTP relay, upgrading to TLS with STARTTLS
+// when the server offers it.
+type SMTP struct {
+	from string
+	host string
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTP creates an SMTP mailer.
+func NewSMTP(cfg Config) *SMTP {
+	port := cfg.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	return &SMTP{
+		from: cfg.From,
+		host: cfg.Host,
+		addr: net.JoinHostPort(cfg.Host, port),
+		auth: auth,
+	}
+}
+
+// Send implements Mailer. It gives up when ctx is done or after sendTimeout,
+// whichever comes first.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	if err := s.send(ctx, msg); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
+
+// send does what smtp.SendMail does, over a connection that is closed as soon
+// as ctx is done.
+func (s *SMTP) send(ctx context.Context, msg Message) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(s.auth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.encode(s.from)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
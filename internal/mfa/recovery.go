@@ -0,0 +1,141 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// recoveryCodeCount is how many recovery codes are issued at a time.
+const recoveryCodeCount = 10
+
+// recoveryAlphabet avoids characters that are easy to misread.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// recoveryCodeLen is the number of characters in a code, not counting the dash.
+const recoveryCodeLen = 10
+
+// generateRecoveryCodes returns fresh codes in the form xxxxx-xxxxx.
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		chars, err := randomRecoveryChars(recoveryCodeLen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		codes[i] = string(chars[:5]) + "-" + string(chars[5:])
+	}
+	return codes, nil
+}
+
+// randomRecoveryChars picks n characters uniformly from recoveryAlphabet.
+// Random bytes at or above the largest multiple of the alphabet size are
+// thrown away, so no character comes up more often than the others.
+func randomRecoveryChars(n int) ([]byte, error) {
+	limit := 256 - 256%len(recoveryAlphabet)
+	chars := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(chars) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(chars) < n {
+				chars = append(chars, recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+			}
+		}
+	}
+	return chars, nil
+}
+
+// hashRecoveryCode hashes a normalized code. Codes carry enough entropy that
+// a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaceRecoveryCodes discards any existing codes and stores new ones.
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID int64) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`,
+			userID,
+			hashRecoveryCode(code),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes invalidates every outstanding recovery code and
+// returns a new set. The plaintext codes are only ever shown once.
+func RegenerateRecoveryCodes(ctx context.Context, db *sqlx.DB, userID int64) ([]string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode consumes a recovery code. Each code works exactly once.
+func UseRecoveryCode(ctx context.Context, db *sqlx.DB, userID int64, code string) (bool, error) {
+	result, err := db.ExecContext(
+		ctx,
+		`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID,
+		hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RemainingRecoveryCodes returns how many of the user's recovery codes are
+// still unused.
+func RemainingRecoveryCodes(ctx context.Context, db *sqlx.DB, userID int64) (int, error) {
+	var count int
+	err := db.GetContext(
+		ctx,
+		&count,
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,33 @@
+package mfa
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	format := regexp.MustCompile(`^[` + recoveryAlphabet + `]{5}-[` + recoveryAlphabet + `]{5}$`)
+	seen := make(map[rune]bool)
+	// Enough codes that every character should turn up
+	for range 20 {
+		codes, err := generateRecoveryCodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(codes) != recoveryCodeCount {
+			t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+		}
+		for _, code := range codes {
+			if !format.MatchString(code) {
+				t.Fatalf("got code %q, want xxxxx-xxxxx from the alphabet", code)
+			}
+			for _, c := range strings.ReplaceAll(code, "-", "") {
+				seen[c] = true
+			}
+		}
+	}
+	if len(seen) != len(recoveryAlphabet) {
+		t.Errorf("got %d distinct characters, want all %d", len(seen), len(recoveryAlphabet))
+	}
+}
@@ -0,0 +1,173 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNotEnrolled is returned when the user has no TOTP secret.
+var ErrNotEnrolled = errors.New("totp not enrolled")
+
+// ErrAlreadyEnabled is returned when enrolling a user whose TOTP is already confirmed.
+var ErrAlreadyEnabled = errors.New("totp already enabled")
+
+// TOTP is a user's authenticator enrollment. It only counts as a second
+// factor once ConfirmedAt is set.
+type TOTP struct {
+	UserId       int64      `db:"user_id"`
+	Secret       string     `db:"secret"`
+	ConfirmedAt  *time.Time `db:"confirmed_at"`
+	LastUsedStep int64      `db:"last_used_step"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+// ByUser returns the user's TOTP enrollment, confirmed or not.
+func ByUser(ctx context.Context, db *sqlx.DB, userID int64) (*TOTP, error) {
+	var t TOTP
+	err := db.GetContext(ctx, &t, `SELECT * FROM user_totp WHERE user_id = ?`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotEnrolled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+	return &t, nil
+}
+
+// IsEnabled reports whether the user has a confirmed TOTP enrollment.
+func IsEnabled(ctx context.Context, db *sqlx.DB, userID int64) (bool, error) {
+	var enabled bool
+	err := db.GetContext(
+		ctx,
+		&enabled,
+		`SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = ? AND confirmed_at IS NOT NULL)`,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to check totp: %w", err)
+	}
+	return enabled, nil
+}
+
+// Enroll stores a new unconfirmed secret, replacing any earlier unconfirmed one.
+func Enroll(ctx context.Context, db *sqlx.DB, userID int64, secret string) error {
+	result, err := db.ExecContext(
+		ctx,
+		`INSERT INTO user_totp (user_id, secret) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = excluded.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE user_totp.confirmed_at IS NULL`,
+		userID,
+		secret,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enroll totp: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrAlreadyEnabled
+	}
+	return nil
+}
+
+// Confirm activates an enrollment after the user proved they can generate
+// codes, and issues the initial recovery codes.
+func Confirm(ctx context.Context, db *sqlx.DB, userID int64, step int64) ([]string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = ?
+		WHERE user_id = ? AND confirmed_at IS NULL`,
+		step,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm totp: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if n == 0 {
+		return nil, ErrAlreadyEnabled
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit totp confirmation: %w", err)
+	}
+	return codes, nil
+}
+
+// Verify checks a TOTP code for a confirmed enrollment. A time step can only
+// be used once, so an intercepted code cannot be replayed.
+func Verify(ctx context.Context, db *sqlx.DB, userID int64, code string) (bool, error) {
+	t, err := ByUser(ctx, db, userID)
+	if err != nil {
+		return false, err
+	}
+	if t.ConfirmedAt == nil {
+		return false, ErrNotEnrolled
+	}
+
+	step, ok := Validate(t.Secret, code, time.Now())
+	if !ok || step <= t.LastUsedStep {
+		return false, nil
+	}
+
+	result, err := db.ExecContext(
+		ctx,
+		`UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`,
+		step,
+		userID,
+		step,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record totp step: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n == 1, nil
+}
+
+// Disable removes the enrollment and every recovery code.
+func Disable(ctx context.Context, db *sqlx.DB, userID int64) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete totp: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit totp removal: %w", err)
+	}
+	return nil
+}
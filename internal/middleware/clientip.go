@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ClientIPKey holds the client IP resolved by RealIP.
+const ClientIPKey contextKey = "clientIP"
+
+// ParseTrustedProxies parses the addresses of reverse proxies whose forwarding
+// headers are believed. Each value is an IP address or a CIDR range.
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range values {
+		if strings.Contains(v, "/") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// RealIP resolves the IP address of the client behind each request and
+// stores it for ClientIP. Forwarding headers are only read when the request
+// comes from a trusted proxy, since anyone else can set them to anything.
+// X-Forwarded-For is read right to left, skipping trusted proxies, so the
+// client is the last address no trusted proxy vouches for.
+func RealIP(trusted []netip.Prefix) Middleware {
+	isTrusted := func(addr netip.Addr) bool {
+		return slices.ContainsFunc(trusted, func(p netip.Prefix) bool {
+			return p.Contains(addr)
+		})
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			if addr, err := netip.ParseAddr(ip); err == nil && isTrusted(addr.Unmap()) {
+				ip = forwardedIP(r, addr.Unmap(), isTrusted)
+			}
+			ctx := context.WithValue(r.Context(), ClientIPKey, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// forwardedIP returns the client IP named by the forwarding headers a trusted
+// proxy at peer added, or peer itself when they name none.
+func forwardedIP(r *http.Request, peer netip.Addr, isTrusted func(netip.Addr) bool) string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		if xri, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return xri.Unmap().String()
+		}
+		return peer.String()
+	}
+
+	client := peer
+	for _, hop := range slices.Backward(hops) {
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			// Garbage can only have come from the client; the last hop a
+			// trusted proxy added is as close as we can get
+			break
+		}
+		client = addr.Unmap()
+		if !isTrusted(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// remoteIP returns the address of the immediate peer, without its port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP returns the client IP resolved by RealIP, or the address of the
+// immediate peer if RealIP did not run.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ClientIPKey).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
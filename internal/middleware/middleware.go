@@ -2,16 +2,24 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"citadel/internal/auth"
 	"citadel/internal/cache"
+	"citadel/internal/user"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -67,7 +75,7 @@ func RequestLogger(logger *slog.Logger) Middleware {
 			reqLogger.Info("request started",
 				"method", r.Method,
 				"path", r.URL.Path,
-				"client_ip", getClientIP(r),
+				"client_ip", ClientIP(r),
 			)
 
 			next.ServeHTTP(rec, r)
@@ -78,25 +86,19 @@ func RequestLogger(logger *slog.Logger) Middleware {
 				"path", r.URL.Path,
 				"status", rec.status,
 				"duration_ms", time.Since(start).Milliseconds(),
-				"client_ip", getClientIP(r),
+				"client_ip", ClientIP(r),
 			)
 		})
 	}
 }
 
-// getClientIP extracts the client IP from the request.
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first (for proxied requests)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+// bearerToken returns the token of a Bearer Authorization header, if any.
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
 	}
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
+	return token
 }
 
 // GetRequestID extracts the request ID from the context.
@@ -117,26 +119,32 @@ func GetLogger(r *http.Request) *slog.Logger {
 	return slog.Default()
 }
 
-// RequireAuth returns authentication middleware that validates JWT tokens.
-func RequireAuth(issuer *auth.Issuer, client *redis.Client) Middleware {
+// GetClaims extracts the authenticated user's claims from the context.
+func GetClaims(r *http.Request) (*auth.Claims, bool) {
+	claims, ok := r.Context().Value(ClaimsKey).(*auth.Claims)
+	return claims, ok
+}
+
+// RequireAuth returns authentication middleware that validates JWT tokens
+// and personal access tokens. Browser clients in cookie session mode send the
+// access token in a cookie instead of the Authorization header. Revocation is
+// checked against the local revocation cache before Redis.
+func RequireAuth(
+	issuer *auth.Issuer,
+	revocations *cache.Revocations,
+	client *redis.Client,
+	db *sqlx.DB,
+) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
 
-			var token string
-
 			// Try Authorization header first
-			authHeader := r.Header.Get("Authorization")
-			if authHeader != "" {
-				parts := strings.SplitN(authHeader, " ", 2)
-				if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
-					token = parts[1]
-				}
-			}
-
-			// Fall back to query param (for SSE which doesn't support headers)
+			token := bearerToken(r)
 			if token == "" {
-				token = r.URL.Query().Get("token")
+				if cookie, err := r.Cookie(AccessCookie); err == nil {
+					token = cookie.Value
+				}
 			}
 
 			if token == "" {
@@ -146,6 +154,29 @@ func RequireAuth(issuer *auth.Issuer, client *redis.Client) Middleware {
 				return
 			}
 
+			if strings.HasPrefix(token, user.APITokenPrefix) {
+				claims, err := apiTokenClaims(ctx, db, token, ClientIP(r))
+				if errors.Is(err, user.ErrAPITokenInvalid) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusUnauthorized)
+					json.NewEncoder(w).
+						Encode(map[string]string{"error": "Invalid or expired token"})
+					return
+				}
+				if err != nil {
+					GetLogger(r).Error("failed to authenticate api token", "error", err)
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					json.NewEncoder(w).
+						Encode(map[string]string{"error": "Authentication service unavailable"})
+					return
+				}
+
+				ctx = context.WithValue(ctx, ClaimsKey, claims)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			claims, err := issuer.Validate(token)
 			if err != nil {
 				w.Header().Set("Content-Type", "application/json")
@@ -154,13 +185,24 @@ func RequireAuth(issuer *auth.Issuer, client *redis.Client) Middleware {
 				return
 			}
 
-			isBlacklisted, err := cache.IsBlacklisted(ctx, client, claims.ID)
-			if err != nil {
+			var expiresAt time.Time
+			if claims.ExpiresAt != nil {
+				expiresAt = claims.ExpiresAt.Time
+			}
+			isBlacklisted, err := revocations.IsBlacklisted(ctx, claims.ID, expiresAt)
+			if err != nil && !revocations.FailOpen() {
+				GetLogger(r).Error("failed to check token revocation", "error", err)
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				json.NewEncoder(w).Encode(map[string]string{"error": "Authentication service unavailable"})
 				return
 			}
+			if err != nil {
+				GetLogger(r).Warn("failed to check token revocation, admitting token",
+					"error", err,
+					"user_id", claims.UserId,
+				)
+			}
 			if isBlacklisted {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusUnauthorized)
@@ -168,23 +210,206 @@ func RequireAuth(issuer *auth.Issuer, client *redis.Client) Middleware {
 				return
 			}
 
+			epoch, err := tokenEpoch(ctx, revocations.GetTokenEpoch, client, db, claims.UserId)
+			if err != nil && !errors.Is(err, user.ErrNotFound) {
+				GetLogger(r).Error("failed to get token epoch", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).
+					Encode(map[string]string{"error": "Authentication service unavailable"})
+				return
+			}
+			if err != nil || claims.TokenEpoch < epoch {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Token has been revoked"})
+				return
+			}
+
+			// Every line logged for an impersonated request names both users
+			if claims.Impersonated() {
+				reqLogger := GetLogger(r).With(
+					"impersonated_user_id", claims.UserId,
+					"impersonator_id", claims.Actor.UserId,
+				)
+				ctx = context.WithValue(ctx, LoggerKey, reqLogger)
+			}
+
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireStreamTicket returns authentication middleware for SSE routes.
+// EventSource cannot send headers, so instead of a token in the query string,
+// which would end up in access logs and browser history, the client passes a
+// short-lived single-use ticket minted for it while authenticated.
+func RequireStreamTicket(client *redis.Client) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			ticket := r.URL.Query().Get("ticket")
+			if ticket == "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Missing stream ticket"})
+				return
+			}
+
+			claims, err := cache.ConsumeStreamTicket(ctx, client, ticket)
+			if errors.Is(err, cache.ErrTicketNotFound) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).
+					Encode(map[string]string{"error": "Invalid or expired stream ticket"})
+				return
+			}
+			if err != nil {
+				GetLogger(r).Error("failed to consume stream ticket", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).
+					Encode(map[string]string{"error": "Authentication service unavailable"})
+				return
+			}
+
 			ctx = context.WithValue(ctx, ClaimsKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// TokenEpoch returns the user's current token epoch, from Redis when cached.
+// Access tokens carrying an older epoch have been revoked.
+func TokenEpoch(
+	ctx context.Context,
+	client *redis.Client,
+	db *sqlx.DB,
+	userID int64,
+) (int64, error) {
+	get := func(ctx context.Context, userID int64) (int64, error) {
+		return cache.GetTokenEpoch(ctx, client, userID)
+	}
+	return tokenEpoch(ctx, get, client, db, userID)
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// tokenEpoch returns the user's token epoch from get, or from the database
+// when it is not cached. The database is authoritative, so it is also used
+// while Redis is unreachable.
+func tokenEpoch(
+	ctx context.Context,
+	get func(context.Context, int64) (int64, error),
+	client *redis.Client,
+	db *sqlx.DB,
+	userID int64,
+) (int64, error) {
+	epoch, cacheErr := get(ctx, userID)
+	if cacheErr == nil {
+		return epoch, nil
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	epoch, err := user.TokenEpoch(ctx, db, userID)
+	if err != nil {
+		return 0, err
+	}
+	if !errors.Is(cacheErr, cache.ErrEpochNotCached) {
+		return epoch, nil
+	}
+	if err := cache.FillTokenEpoch(ctx, client, userID, epoch); err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// apiTokenClaims builds claims for a personal access token from the owner's
+// current roles. A scoped token keeps only the permissions and scopes it was
+// scoped to.
+func apiTokenClaims(
+	ctx context.Context,
+	db *sqlx.DB,
+	token string,
+	ip string,
+) (*auth.Claims, error) {
+	t, err := user.AuthenticateAPIToken(ctx, db, token, ip)
+	if err != nil {
+		return nil, err
+	}
+	u, err := user.ByID(ctx, db, t.UserId)
+	// Tokens of a deleted account stop working until they are purged with it
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, user.ErrAPITokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Signing out everywhere ends API tokens as well as sessions
+	if u.SuspendedAt != nil || t.TokenEpoch < u.TokenEpoch {
+		return nil, user.ErrAPITokenInvalid
+	}
+	roles, err := user.Roles(ctx, db, u.UserId)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := user.Permissions(ctx, db, u.UserId)
+	if err != nil {
+		return nil, err
+	}
+	scope := auth.FullScope(permissions)
+	if scopes := t.ScopeList(); len(scopes) > 0 {
+		permissions = slices.DeleteFunc(permissions, func(p string) bool {
+			return !slices.Contains(scopes, p)
+		})
+		scope = auth.RetainScope(t.Scopes, scope)
+	}
+
+	claims := &auth.Claims{
+		UserId:      u.UserId,
+		Username:    u.Username,
+		Email:       u.Email,
+		Roles:       roles,
+		Permissions: permissions,
+		Scope:       scope,
+		APITokenID:  t.TokenId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       fmt.Sprintf("api-token-%d", t.TokenId),
+			Subject:  strconv.FormatInt(u.UserId, 10),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	if t.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*t.ExpiresAt)
+	}
+	return claims, nil
+}
+
+// CORS allows cross-origin requests from anywhere. Requests from
+// credentialOrigins may also include cookies, which cookie session mode needs
+// when the web app is served from a different origin than the API.
+func CORS(credentialOrigins ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && slices.Contains(credentialOrigins, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set(
+				"Access-Control-Allow-Headers",
+				"Content-Type, Authorization, "+CSRFHeader+", "+SessionModeHeader,
+			)
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
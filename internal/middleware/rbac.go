@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"citadel/internal/auth"
+)
+
+// RequireRole returns middleware that only admits tokens carrying one of the roles.
+// Must run after RequireAuth.
+func RequireRole(roles ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaims(r)
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			if !slices.ContainsFunc(roles, claims.HasRole) {
+				GetLogger(r).Warn("access denied: missing role",
+					"user_id", claims.UserId,
+					"required_roles", roles,
+				)
+				forbidden(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission returns middleware that only admits tokens carrying the permission.
+// Must run after RequireAuth.
+func RequirePermission(permission string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaims(r)
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			if !claims.HasPermission(permission) {
+				GetLogger(r).Warn("access denied: missing permission",
+					"user_id", claims.UserId,
+					"required_permission", permission,
+				)
+				forbidden(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope returns middleware that only admits tokens granted every one of
+// the scopes. Must run after RequireAuth.
+func RequireScope(scopes ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaims(r)
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					GetLogger(r).Warn("access denied: insufficient scope",
+						"user_id", claims.UserId,
+						"required_scopes", scopes,
+					)
+					insufficientScope(w, scopes...)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSelfOrPermission returns middleware for self-service routes. It admits
+// the user named by the path parameter if their token has the account scope,
+// or anyone carrying the permission along with the scope of the same name.
+// Must run after RequireAuth.
+func RequireSelfOrPermission(param, permission string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaims(r)
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			scope := permission
+			if r.PathValue(param) == strconv.FormatInt(claims.UserId, 10) {
+				scope = auth.ScopeAccount
+			} else if !claims.HasPermission(permission) {
+				GetLogger(r).Warn("access denied: not owner and missing permission",
+					"user_id", claims.UserId,
+					"target", r.PathValue(param),
+					"required_permission", permission,
+				)
+				forbidden(w)
+				return
+			}
+
+			if !claims.HasScope(scope) {
+				GetLogger(r).Warn("access denied: insufficient scope",
+					"user_id", claims.UserId,
+					"target", r.PathValue(param),
+					"required_scopes", []string{scope},
+				)
+				insufficientScope(w, scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DenyImpersonation rejects impersonation tokens, for routes that change how
+// the user signs in or that mint new credentials. Must run after RequireAuth.
+func DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetClaims(r)
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		if claims.Impersonated() {
+			GetLogger(r).Warn("access denied: not allowed while impersonating",
+				"method", r.Method,
+				"path", r.URL.Path,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Not allowed while impersonating"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+}
+
+func forbidden(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Forbidden"})
+}
+
+// insufficientScope answers with the RFC 6750 insufficient_scope error, naming
+// the scopes the route needs so the client can sign in again with them.
+func insufficientScope(w http.ResponseWriter, scopes ...string) {
+	scope := strings.Join(scopes, " ")
+	w.Header().Set(
+		"WWW-Authenticate",
+		fmt.Sprintf(`Bearer realm="citadel", error="insufficient_scope", scope=%q`, scope),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "insufficient_scope",
+		"scope": scope,
+	})
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"citadel/internal/auth"
+)
+
+// serve sends a request for path through m to a handler answering 204. The
+// request is authenticated as claims, or not at all if claims is nil.
+func serve(m Middleware, claims *auth.Claims, pattern, path string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	r := httptest.NewRequest("GET", path, nil)
+	if claims != nil {
+		r = r.WithContext(context.WithValue(r.Context(), ClaimsKey, claims))
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		claims *auth.Claims
+		want   int
+	}{
+		{
+			name:   "scope granted",
+			scopes: []string{"users:read"},
+			claims: &auth.Claims{Scope: "account users:read"},
+			want:   http.StatusNoContent,
+		},
+		{
+			name:   "every scope granted",
+			scopes: []string{"users:read", "users:write"},
+			claims: &auth.Claims{Scope: "users:write users:read"},
+			want:   http.StatusNoContent,
+		},
+		{
+			name:   "scope missing",
+			scopes: []string{"users:write"},
+			claims: &auth.Claims{Scope: "account users:read"},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "one of several scopes missing",
+			scopes: []string{"users:read", "users:write"},
+			claims: &auth.Claims{Scope: "users:read"},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "permission without the scope",
+			scopes: []string{"users:write"},
+			claims: &auth.Claims{Permissions: []string{"users:write"}, Scope: "account"},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "no scopes",
+			scopes: []string{"account"},
+			claims: &auth.Claims{},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "unauthenticated",
+			scopes: []string{"account"},
+			want:   http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(RequireScope(tt.scopes...), tt.claims, "GET /", "/")
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusForbidden {
+				want := `Bearer realm="citadel", error="insufficient_scope", scope="` +
+					strings.Join(tt.scopes, " ") + `"`
+				if got := w.Header().Get("WWW-Authenticate"); got != want {
+					t.Errorf("got WWW-Authenticate %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireSelfOrPermission(t *testing.T) {
+	const permission = "users:read"
+
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		path   string
+		want   int
+	}{
+		{
+			name:   "self with the account scope",
+			claims: &auth.Claims{UserId: 1, Scope: "account"},
+			path:   "/users/1",
+			want:   http.StatusNoContent,
+		},
+		{
+			name:   "self without the account scope",
+			claims: &auth.Claims{UserId: 1, Scope: "users:read"},
+			path:   "/users/1",
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "other user with the permission and scope",
+			claims: &auth.Claims{
+				UserId:      1,
+				Permissions: []string{permission},
+				Scope:       "users:read",
+			},
+			path: "/users/2",
+			want: http.StatusNoContent,
+		},
+		{
+			name: "other user with the permission but not the scope",
+			claims: &auth.Claims{
+				UserId:      1,
+				Permissions: []string{permission},
+				Scope:       "account",
+			},
+			path: "/users/2",
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "other user with the scope but not the permission",
+			claims: &auth.Claims{UserId: 1, Scope: "account users:read"},
+			path:   "/users/2",
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "unauthenticated",
+			path: "/users/1",
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := RequireSelfOrPermission("id", permission)
+			if w := serve(m, tt.claims, "GET /users/{id}", tt.path); w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
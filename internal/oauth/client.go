@@ -0,0 +1,182 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrClientNotFound is returned when no client has the given ID.
+var ErrClientNotFound = errors.New("client not found")
+
+// ErrInvalidRedirect is returned when registering a redirect URI that is not
+// an absolute URI without a fragment.
+var ErrInvalidRedirect = errors.New("invalid redirect uri")
+
+// Client is an application registered to sign users in through citadel.
+// Public clients (single page and native apps) have no secret and rely on
+// PKCE alone; confidential clients must also authenticate at the token endpoint.
+type Client struct {
+	ClientID     string         `db:"client_id" json:"client_id"`
+	Name         string         `db:"name" json:"name"`
+	SecretHash   sql.NullString `db:"secret_hash" json:"-"`
+	RedirectURIs string         `db:"redirect_uris" json:"-"`
+	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
+}
+
+// Confidential reports whether the client was issued a secret.
+func (c *Client) Confidential() bool {
+	return c.SecretHash.Valid
+}
+
+// Redirects returns the registered redirect URIs.
+func (c *Client) Redirects() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+// AllowsRedirect reports whether uri exactly matches a registered redirect URI.
+func (c *Client) AllowsRedirect(uri string) bool {
+	return slices.Contains(c.Redirects(), uri)
+}
+
+// Authenticate checks a client secret in constant time.
+func (c *Client) Authenticate(secret string) bool {
+	if !c.SecretHash.Valid || secret == "" {
+		return false
+	}
+	hashed := hashSecret(secret)
+	return subtle.ConstantTimeCompare([]byte(hashed), []byte(c.SecretHash.String)) == 1
+}
+
+// CreateClient registers a client. The secret is only returned here and is
+// empty for public clients.
+func CreateClient(
+	ctx context.Context,
+	db *sqlx.DB,
+	name string,
+	redirectURIs []string,
+	confidential bool,
+) (*Client, string, error) {
+	for _, uri := range redirectURIs {
+		if err := validateRedirect(uri); err != nil {
+			return nil, "", err
+		}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate client id: %w", err)
+	}
+
+	var secret string
+	var secretHash sql.NullString
+	if confidential {
+		secret, err = randomToken(32)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to generate client secret: %w", err)
+		}
+		secretHash = sql.NullString{String: hashSecret(secret), Valid: true}
+	}
+
+	_, err = db.ExecContext(
+		ctx,
+		`INSERT INTO oauth_clients (client_id, name, secret_hash, redirect_uris)
+		VALUES (?, ?, ?, ?)`,
+		clientID,
+		name,
+		secretHash,
+		strings.Join(redirectURIs, " "),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create client: %w", err)
+	}
+
+	client, err := ClientByID(ctx, db, clientID)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+// ClientByID returns a registered client.
+func ClientByID(ctx context.Context, db *sqlx.DB, clientID string) (*Client, error) {
+	var c Client
+	err := db.GetContext(ctx, &c, `SELECT * FROM oauth_clients WHERE client_id = ?`, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	return &c, nil
+}
+
+// ListClients returns every registered client.
+func ListClients(ctx context.Context, db *sqlx.DB) ([]Client, error) {
+	clients := []Client{}
+	err := db.SelectContext(ctx, &clients, `SELECT * FROM oauth_clients ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	return clients, nil
+}
+
+// DeleteClient removes a client. Tokens it already holds stay valid until
+// they expire.
+func DeleteClient(ctx context.Context, db *sqlx.DB, clientID string) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE client_id = ?`, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	if rows == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// validateRedirect rejects redirect URIs that cannot be matched exactly.
+func validateRedirect(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return fmt.Errorf("%w: %q", ErrInvalidRedirect, uri)
+	}
+	// Native apps may use a private-use scheme without a host
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidRedirect, uri)
+	}
+	return nil
+}
+
+func randomToken(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewCode generates an authorization code.
+func NewCode() (string, error) {
+	return randomToken(32)
+}
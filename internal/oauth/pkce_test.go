@@ -0,0 +1,76 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// The example from RFC 7636 appendix B.
+const (
+	rfcVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	// stdChallenge is rfcChallenge in the standard, not the URL, alphabet
+	stdChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw+cM"
+)
+
+func challengeFor(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	shortest := strings.Repeat("a", 43)
+	longest := strings.Repeat("~", 128)
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		valid     bool
+	}{
+		{"RFC 7636 example", rfcChallenge, rfcVerifier, true},
+		{"shortest verifier", challengeFor(shortest), shortest, true},
+		{"longest verifier", challengeFor(longest), longest, true},
+		{"wrong verifier", rfcChallenge, rfcVerifier[1:] + "A", false},
+		{"verifier too short", challengeFor(shortest[1:]), shortest[1:], false},
+		{"verifier too long", challengeFor(longest + "~"), longest + "~", false},
+		{"verifier outside the grammar", challengeFor(shortest + "+"), shortest + "+", false},
+		{"empty verifier", challengeFor(""), "", false},
+		// The plain method sends the verifier itself as the challenge
+		{"plain challenge", rfcVerifier, rfcVerifier, false},
+		{"padded challenge", rfcChallenge + "=", rfcVerifier, false},
+		{"standard base64 challenge", stdChallenge, rfcVerifier, false},
+		{"empty challenge", "", rfcVerifier, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.challenge, tt.verifier); got != tt.valid {
+				t.Errorf("got %t, want %t", got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		valid     bool
+	}{
+		{"S256 digest", rfcChallenge, true},
+		{"plain verifier", strings.Repeat("a", 64), false},
+		{"padded", rfcChallenge + "=", false},
+		{"standard base64", stdChallenge, false},
+		{"too short", rfcChallenge[:42], false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidChallenge(tt.challenge); got != tt.valid {
+				t.Errorf("got %t, want %t", got, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package oauth
+
+import (
+	"slices"
+	"strings"
+)
+
+// Scopes understood by the provider. openid is required on every request.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// SupportedScopes is advertised in the discovery document.
+var SupportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// ParseScope splits a space-delimited scope string, drops scopes the provider
+// does not know and removes duplicates.
+func ParseScope(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if slices.Contains(SupportedScopes, s) && !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// HasScope reports whether a space-delimited scope string contains scope.
+func HasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
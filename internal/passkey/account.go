@@ -0,0 +1,61 @@
+package passkey
+
+import (
+	"context"
+	"strconv"
+
+	"citadel/internal/user"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/jmoiron/sqlx"
+)
+
+// Account adapts a user and their passkeys to webauthn.User.
+type Account struct {
+	User        *user.User
+	Credentials []Credential
+}
+
+// LoadAccount loads a user together with their registered passkeys.
+func LoadAccount(ctx context.Context, db *sqlx.DB, userID int64) (*Account, error) {
+	u, err := user.ByID(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := ByUser(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{User: u, Credentials: credentials}, nil
+}
+
+// UserHandle is the opaque user ID stored on the authenticator. Discoverable
+// logins hand it back so the account can be found without a username.
+func UserHandle(userID int64) []byte {
+	return []byte(strconv.FormatInt(userID, 10))
+}
+
+// ParseUserHandle is the inverse of UserHandle.
+func ParseUserHandle(handle []byte) (int64, error) {
+	return strconv.ParseInt(string(handle), 10, 64)
+}
+
+func (a *Account) WebAuthnID() []byte {
+	return UserHandle(a.User.UserId)
+}
+
+func (a *Account) WebAuthnName() string {
+	return a.User.Email
+}
+
+func (a *Account) WebAuthnDisplayName() string {
+	return a.User.Username
+}
+
+func (a *Account) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(a.Credentials))
+	for i := range a.Credentials {
+		credentials[i] = a.Credentials[i].WebAuthn()
+	}
+	return credentials
+}
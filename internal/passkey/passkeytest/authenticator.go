@@ -0,0 +1,224 @@
+// Package passkeytest provides a software WebAuthn authenticator so passkey
+// registration and login can be driven end to end from Go tests, the same
+// way a browser and platform authenticator would.
+package passkeytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// Authenticator flags from the WebAuthn authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Authenticator is an in-memory authenticator holding discoverable ES256
+// credentials. It always reports user presence and verification and uses the
+// "none" attestation format.
+type Authenticator struct {
+	// Origin is reported in the client data, as a browser would.
+	Origin string
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// New returns an authenticator that answers ceremonies on behalf of origin.
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Create answers navigator.credentials.create() and returns the JSON the
+// browser would send back to the relying party.
+func (a *Authenticator) Create(options protocol.CredentialCreation) (json.RawMessage, error) {
+	opts := options.Response
+
+	userHandle, err := userHandleBytes(opts.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, excluded := range opts.CredentialExcludeList {
+		for _, c := range a.credentials {
+			if string(c.id) == string(excluded.CredentialID) {
+				return nil, errors.New("credential already registered")
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	cred := &credential{id: id, rpID: opts.RelyingParty.ID, userHandle: userHandle, key: key}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: key.X.FillBytes(make([]byte, 32)),
+		YCoord: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Attested credential data: AAGUID, credential ID length, ID, public key
+	attested := make([]byte, 16, 16+2+len(id)+len(publicKey))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, publicKey...)
+
+	authData := cred.authData(flagUserPresent | flagUserVerified | flagAttestedData)
+	authData = append(authData, attested...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	clientData, err := a.clientData(protocol.CreateCeremony, opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	a.credentials = append(a.credentials, cred)
+	return json.Marshal(map[string]any{
+		"id":                      encode(id),
+		"rawId":                   encode(id),
+		"type":                    "public-key",
+		"authenticatorAttachment": "platform",
+		"response": map[string]any{
+			"clientDataJSON":    encode(clientData),
+			"attestationObject": encode(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// Get answers navigator.credentials.get() with the first credential for the
+// relying party that the options allow, and returns the JSON the browser
+// would send back.
+func (a *Authenticator) Get(options protocol.CredentialAssertion) (json.RawMessage, error) {
+	opts := options.Response
+
+	cred := a.find(opts.RelyingPartyID, opts.AllowedCredentials)
+	if cred == nil {
+		return nil, errors.New("no matching credential")
+	}
+	cred.signCount++
+
+	authData := cred.authData(flagUserPresent | flagUserVerified)
+	clientData, err := a.clientData(protocol.AssertCeremony, opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{
+		"id":                      encode(cred.id),
+		"rawId":                   encode(cred.id),
+		"type":                    "public-key",
+		"authenticatorAttachment": "platform",
+		"response": map[string]any{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode(cred.userHandle),
+		},
+	})
+}
+
+func (a *Authenticator) find(
+	rpID string,
+	allowed []protocol.CredentialDescriptor,
+) *credential {
+	for _, c := range a.credentials {
+		if c.rpID != rpID {
+			continue
+		}
+		if len(allowed) == 0 {
+			return c
+		}
+		for _, d := range allowed {
+			if string(d.CredentialID) == string(c.id) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(
+	ceremony protocol.CeremonyType,
+	challenge protocol.URLEncodedBase64,
+) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   encode(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+// authData builds the fixed authenticator data prefix: RP ID hash, flags and
+// signature counter.
+func (c *credential) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, c.signCount)
+}
+
+// userHandleBytes accepts the user ID from options built in process or
+// decoded from JSON.
+func userHandleBytes(id any) ([]byte, error) {
+	switch v := id.(type) {
+	case protocol.URLEncodedBase64:
+		return v, nil
+	case []byte:
+		return v, nil
+	case string:
+		return base64.RawURLEncoding.DecodeString(v)
+	default:
+		return nil, fmt.Errorf("unsupported user id type %T", id)
+	}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
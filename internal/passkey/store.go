@@ -0,0 +1,177 @@
+package passkey
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNotFound is returned when a user has no passkey with the given ID.
+var ErrNotFound = errors.New("passkey not found")
+
+// Credential is a stored WebAuthn credential. A user can register several,
+// one per device or password manager.
+type Credential struct {
+	CredentialId    []byte     `db:"credential_id"`
+	UserId          int64      `db:"user_id"`
+	Name            string     `db:"name"`
+	PublicKey       []byte     `db:"public_key"`
+	AttestationType string     `db:"attestation_type"`
+	Transports      string     `db:"transports"`
+	Flags           uint8      `db:"flags"`
+	AAGUID          []byte     `db:"aaguid"`
+	SignCount       uint32     `db:"sign_count"`
+	CloneWarning    bool       `db:"clone_warning"`
+	LastUsedAt      *time.Time `db:"last_used_at"`
+	CreatedAt       time.Time  `db:"created_at"`
+}
+
+// ID returns the credential ID in the base64url form WebAuthn clients use.
+func (c *Credential) ID() string {
+	return EncodeID(c.CredentialId)
+}
+
+// EncodeID encodes a raw credential ID as base64url.
+func EncodeID(credentialID []byte) string {
+	return base64.RawURLEncoding.EncodeToString(credentialID)
+}
+
+// WebAuthn converts the stored row into the library's credential record.
+func (c *Credential) WebAuthn() webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, t := range strings.Fields(c.Transports) {
+		transports = append(transports, protocol.AuthenticatorTransport(t))
+	}
+	return webauthn.Credential{
+		ID:              c.CredentialId,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(c.Flags)),
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       c.AAGUID,
+			SignCount:    c.SignCount,
+			CloneWarning: c.CloneWarning,
+		},
+	}
+}
+
+// ByUser returns the user's passkeys, oldest first.
+func ByUser(ctx context.Context, db *sqlx.DB, userID int64) ([]Credential, error) {
+	credentials := []Credential{}
+	err := db.SelectContext(
+		ctx,
+		&credentials,
+		`SELECT * FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at, rowid`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	return credentials, nil
+}
+
+// Create stores a newly registered credential.
+func Create(
+	ctx context.Context,
+	db *sqlx.DB,
+	userID int64,
+	name string,
+	credential *webauthn.Credential,
+) error {
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO webauthn_credentials (
+			credential_id, user_id, name, public_key, attestation_type,
+			transports, flags, aaguid, sign_count
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		credential.ID,
+		userID,
+		name,
+		credential.PublicKey,
+		credential.AttestationType,
+		strings.Join(transports, " "),
+		uint8(credential.Flags.ProtocolValue()),
+		credential.Authenticator.AAGUID,
+		credential.Authenticator.SignCount,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store passkey: %w", err)
+	}
+	return nil
+}
+
+// RecordUse saves the signature counter and flags reported by a successful
+// assertion.
+func RecordUse(ctx context.Context, db *sqlx.DB, credential *webauthn.Credential) error {
+	_, err := db.ExecContext(
+		ctx,
+		`UPDATE webauthn_credentials SET
+			sign_count = ?,
+			clone_warning = ?,
+			flags = ?,
+			last_used_at = CURRENT_TIMESTAMP
+		WHERE credential_id = ?`,
+		credential.Authenticator.SignCount,
+		credential.Authenticator.CloneWarning,
+		uint8(credential.Flags.ProtocolValue()),
+		credential.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record passkey use: %w", err)
+	}
+	return nil
+}
+
+// Delete removes one of the user's passkeys. The ID is in base64url form.
+func Delete(ctx context.Context, db *sqlx.DB, userID int64, id string) error {
+	credentialID, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	result, err := db.ExecContext(
+		ctx,
+		`DELETE FROM webauthn_credentials WHERE credential_id = ? AND user_id = ?`,
+		credentialID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Exists reports whether a credential ID is already registered to anyone.
+func Exists(ctx context.Context, db *sqlx.DB, credentialID []byte) (bool, error) {
+	var exists bool
+	err := db.GetContext(
+		ctx,
+		&exists,
+		`SELECT EXISTS(SELECT 1 FROM webauthn_credentials WHERE credential_id = ?)`,
+		credentialID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to check passkey: %w", err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,155 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// prefixLen is how many bytes of each SHA-1 hash are compared. 64 bits give a
+// negligible chance of a false positive.
+const prefixLen = 8
+
+// maxBreachedLine bounds the length of a line in the list. Lines of the Have
+// I Been Pwned corpus are about 50 bytes.
+const maxBreachedLine = 512
+
+// BreachedList is a set of passwords known from data breaches. It is
+// searched on disk, so even the full Have I Been Pwned corpus costs no
+// memory, and is safe for concurrent use.
+type BreachedList struct {
+	file *os.File
+	size int64
+}
+
+// OpenBreached opens a list of SHA-1 password hashes, one per line in hex and
+// sorted by hash, such as the "ordered by hash" download of the Have I Been
+// Pwned corpus. Lines may be cut to the first 16 hex characters and may carry
+// a ":count" suffix, which is ignored. Blank lines and lines starting with
+// '#' may only appear before the first hash.
+func OpenBreached(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat breached password list: %w", err)
+	}
+
+	l := &BreachedList{file: f, size: info.Size()}
+	// A search reads lines across the whole file, catching most files that
+	// are not a list at all
+	if _, err := l.Contains(""); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Size returns the size of the list in bytes.
+func (l *BreachedList) Size() int64 {
+	return l.size
+}
+
+// Close closes the list.
+func (l *BreachedList) Close() error {
+	return l.file.Close()
+}
+
+// Contains reports whether the password is on the list. It binary searches
+// the byte offsets of the file, reading the line at each one.
+func (l *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := binary.BigEndian.Uint64(sum[:prefixLen])
+
+	// Lines starting in [lo, hi) may still hold the target
+	lo, hi := int64(0), l.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, key, next, err := l.lineAt(mid)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case start >= hi || key > target:
+			hi = mid
+		case key < target:
+			lo = next
+		default:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lineAt finds the first line starting at or after offset and returns where
+// it starts, its hash prefix and where the next line starts. start is the
+// size of the file when there is no such line.
+func (l *BreachedList) lineAt(offset int64) (start int64, key uint64, next int64, err error) {
+	buf := make([]byte, maxBreachedLine)
+
+	start = offset
+	if offset > 0 {
+		// Skip the rest of the line the byte before offset belongs to
+		n, err := l.file.ReadAt(buf, offset-1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, 0, 0, fmt.Errorf("failed to read breached password list: %w", err)
+		}
+		i := bytes.IndexByte(buf[:n], '\n')
+		if i < 0 {
+			if offset-1+int64(n) < l.size {
+				return 0, 0, 0, fmt.Errorf("breached password list: line too long near %d", offset)
+			}
+			return l.size, 0, l.size, nil
+		}
+		start = offset + int64(i)
+	}
+	if start >= l.size {
+		return l.size, 0, l.size, nil
+	}
+
+	n, err := l.file.ReadAt(buf, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, 0, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	line := buf[:n]
+	next = start + int64(n)
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+		next = start + int64(i) + 1
+	} else if next < l.size {
+		return 0, 0, 0, fmt.Errorf("breached password list: line too long at %d", start)
+	}
+
+	key, err = parseBreachedLine(string(line))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("breached password list at %d: %w", start, err)
+	}
+	return start, key, next, nil
+}
+
+// parseBreachedLine returns the hash prefix of a line. Blank lines and
+// comments sort before every hash.
+func parseBreachedLine(line string) (uint64, error) {
+	text := strings.TrimSpace(line)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return 0, nil
+	}
+	text, _, _ = strings.Cut(text, ":")
+	if len(text) < 2*prefixLen {
+		return 0, errors.New("hash too short")
+	}
+	b, err := hex.DecodeString(text[:2*prefixLen])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
@@ -0,0 +1,241 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrUnknownPepper is returned when a stored hash was peppered with a key
+// that is no longer configured. Retire peppers only after every hash using
+// them has been upgraded.
+var ErrUnknownPepper = errors.New("password hash uses an unknown pepper")
+
+// ErrInvalidHash is returned when a stored hash cannot be parsed.
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Params are the argon2id cost parameters.
+type Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultParams follow the OWASP password storage recommendation for
+// argon2id: 19 MiB of memory, two iterations and one degree of parallelism.
+// See: https://cheatsheetseries.owasp.org/cheatsheets/Password_Storage_Cheat_Sheet.html
+var DefaultParams = Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+const (
+	saltLen = 16
+	keyLen  = 32
+)
+
+// Hasher hashes passwords into self-describing PHC strings such as
+//
+//	$argon2id$v=19$m=19456,t=2,p=1,k=2024$<salt>$<hash>
+//
+// where the optional k parameter names the server-side pepper that was mixed
+// in. Because every hash records how it was made, the parameters and pepper
+// can change at any time and older hashes keep verifying until they are
+// upgraded on the next login.
+type Hasher struct {
+	params   Params
+	peppers  map[string][]byte
+	pepperID string
+}
+
+// Config configures a Hasher. PepperID selects which of Peppers is applied
+// to new hashes; leave it empty to hash without a pepper.
+type Config struct {
+	Params   Params
+	Peppers  map[string]string
+	PepperID string
+}
+
+// New creates a Hasher.
+func New(cfg Config) (*Hasher, error) {
+	if cfg.Params.Memory == 0 || cfg.Params.Iterations == 0 || cfg.Params.Parallelism == 0 {
+		return nil, fmt.Errorf("argon2id memory, iterations and parallelism must be positive")
+	}
+
+	h := &Hasher{
+		params:   cfg.Params,
+		peppers:  make(map[string][]byte, len(cfg.Peppers)),
+		pepperID: cfg.PepperID,
+	}
+	for id, pepper := range cfg.Peppers {
+		if !validPepperID(id) {
+			return nil, fmt.Errorf("invalid pepper id %q: use letters, digits and '-'", id)
+		}
+		if pepper == "" {
+			return nil, fmt.Errorf("pepper %q is empty", id)
+		}
+		h.peppers[id] = []byte(pepper)
+	}
+	if cfg.PepperID != "" {
+		if _, ok := h.peppers[cfg.PepperID]; !ok {
+			return nil, fmt.Errorf("pepper %q not found", cfg.PepperID)
+		}
+	}
+	return h, nil
+}
+
+// Hash hashes a password with the current parameters and pepper.
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	input, err := h.pepper(password, h.pepperID)
+	if err != nil {
+		return "", err
+	}
+	p := h.params
+	key := argon2.IDKey(input, salt, p.Iterations, p.Memory, p.Parallelism, keyLen)
+
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	if h.pepperID != "" {
+		params += ",k=" + h.pepperID
+	}
+	return fmt.Sprintf(
+		"$argon2id$v=%d$%s$%s$%s",
+		argon2.Version,
+		params,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks a password against a stored hash. needsRehash reports that
+// the password matched but the hash was made with an older algorithm,
+// parameters or pepper and should be replaced with Hash.
+//
+// Hashes from before PHC strings were introduced are bare base64 scrypt keys
+// with the salt kept in a separate column; pass that salt as legacySalt.
+func (h *Hasher) Verify(
+	password string,
+	encoded string,
+	legacySalt []byte,
+) (match bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$") {
+		match, err := verifyLegacy(password, encoded, legacySalt)
+		return match, true, err
+	}
+
+	parts := strings.Split(encoded, "$")
+	version := fmt.Sprintf("v=%d", argon2.Version)
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != version {
+		return false, false, ErrInvalidHash
+	}
+
+	var p Params
+	var pepperID string
+	for _, kv := range strings.Split(parts[3], ",") {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return false, false, ErrInvalidHash
+		}
+		switch name {
+		case "m":
+			p.Memory, err = parseUint32(value)
+		case "t":
+			p.Iterations, err = parseUint32(value)
+		case "p":
+			var n uint32
+			n, err = parseUint32(value)
+			if n > 255 {
+				err = ErrInvalidHash
+			}
+			p.Parallelism = uint8(n)
+		case "k":
+			pepperID = value
+		default:
+			err = ErrInvalidHash
+		}
+		if err != nil {
+			return false, false, ErrInvalidHash
+		}
+	}
+	// Hash never writes zero costs, and argon2 panics on some of them
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return false, false, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, false, ErrInvalidHash
+	}
+
+	input, err := h.pepper(password, pepperID)
+	if err != nil {
+		return false, false, err
+	}
+	computed := argon2.IDKey(input, salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+
+	return true, p != h.params || pepperID != h.pepperID, nil
+}
+
+// pepper mixes a server-side secret into the password with HMAC-SHA256, so
+// a leaked database alone is not enough to start guessing passwords.
+func (h *Hasher) pepper(password, pepperID string) ([]byte, error) {
+	if pepperID == "" {
+		return []byte(password), nil
+	}
+	secret, ok := h.peppers[pepperID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPepper, pepperID)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return mac.Sum(nil), nil
+}
+
+// verifyLegacy checks the original scrypt hashes: N=32768, r=8, p=1, 32-byte
+// key, base64 encoded, salt stored alongside.
+func verifyLegacy(password, encoded string, salt []byte) (bool, error) {
+	if len(salt) == 0 {
+		return false, ErrInvalidHash
+	}
+	key, err := scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
+	if err != nil {
+		return false, fmt.Errorf("failed to create key: %w", err)
+	}
+	computed := base64.StdEncoding.EncodeToString(key)
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(encoded)) == 1, nil
+}
+
+func parseUint32(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err
+}
+
+func validPepperID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,231 @@
+package password
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// cheap keeps argon2id fast enough for tests.
+var cheap = Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func newHasher(t *testing.T, cfg Config) *Hasher {
+	t.Helper()
+
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestVerifyPHC(t *testing.T) {
+	h := newHasher(t, Config{Params: cheap})
+	encoded, err := h.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(encoded, "$")
+	// withParams replaces the parameters of the valid hash
+	withParams := func(params string) string {
+		return strings.Join([]string{"", parts[1], parts[2], params, parts[4], parts[5]}, "$")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		match    bool
+		wantErr  error
+	}{
+		{"match", "hunter2", encoded, true, nil},
+		{"wrong password", "hunter3", encoded, false, nil},
+		{"parameters in another order", "hunter2", withParams("p=1,t=1,m=64"), true, nil},
+		{"other parameters", "hunter2", withParams("m=64,t=2,p=1"), false, nil},
+		{"argon2i", "hunter2", strings.Replace(encoded, "argon2id", "argon2i", 1), false,
+			ErrInvalidHash},
+		{"old version", "hunter2", strings.Replace(encoded, "v=19", "v=16", 1), false,
+			ErrInvalidHash},
+		{"missing section", "hunter2", strings.Join(parts[:5], "$"), false, ErrInvalidHash},
+		{"zero memory", "hunter2", withParams("m=0,t=1,p=1"), false, ErrInvalidHash},
+		{"zero iterations", "hunter2", withParams("m=64,t=0,p=1"), false, ErrInvalidHash},
+		{"zero parallelism", "hunter2", withParams("m=64,t=1,p=0"), false, ErrInvalidHash},
+		{"missing memory", "hunter2", withParams("t=1,p=1"), false, ErrInvalidHash},
+		{"missing iterations", "hunter2", withParams("m=64,p=1"), false, ErrInvalidHash},
+		{"missing parallelism", "hunter2", withParams("m=64,t=1"), false, ErrInvalidHash},
+		{"parallelism too high", "hunter2", withParams("m=64,t=1,p=256"), false, ErrInvalidHash},
+		{"negative memory", "hunter2", withParams("m=-1,t=1,p=1"), false, ErrInvalidHash},
+		{"unknown parameter", "hunter2", withParams("m=64,t=1,p=1,x=1"), false, ErrInvalidHash},
+		{"parameter without value", "hunter2", withParams("m=64,t,p=1"), false, ErrInvalidHash},
+		{"bad salt", "hunter2", strings.Replace(encoded, parts[4], "!", 1), false,
+			ErrInvalidHash},
+		{"empty key", "hunter2", strings.TrimSuffix(encoded, parts[5]), false, ErrInvalidHash},
+		{"unknown pepper", "hunter2", withParams("m=64,t=1,p=1,k=gone"), false,
+			ErrUnknownPepper},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, _, err := h.Verify(tt.password, tt.encoded, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if match != tt.match {
+				t.Errorf("got match %t, want %t", match, tt.match)
+			}
+		})
+	}
+}
+
+func TestVerifyNeedsRehash(t *testing.T) {
+	peppers := map[string]string{"2024": "old secret", "2025": "new secret"}
+
+	tests := []struct {
+		name string
+		// hashed is how the stored hash was made and current how new ones are
+		hashed, current Config
+		needsRehash     bool
+	}{
+		{
+			name:    "same parameters",
+			hashed:  Config{Params: cheap},
+			current: Config{Params: cheap},
+		},
+		{
+			name:        "more memory",
+			hashed:      Config{Params: cheap},
+			current:     Config{Params: Params{Memory: 128, Iterations: 1, Parallelism: 1}},
+			needsRehash: true,
+		},
+		{
+			name:        "more iterations",
+			hashed:      Config{Params: cheap},
+			current:     Config{Params: Params{Memory: 64, Iterations: 2, Parallelism: 1}},
+			needsRehash: true,
+		},
+		{
+			name:    "same pepper",
+			hashed:  Config{Params: cheap, Peppers: peppers, PepperID: "2025"},
+			current: Config{Params: cheap, Peppers: peppers, PepperID: "2025"},
+		},
+		{
+			name:        "pepper added",
+			hashed:      Config{Params: cheap},
+			current:     Config{Params: cheap, Peppers: peppers, PepperID: "2025"},
+			needsRehash: true,
+		},
+		{
+			name:        "pepper rotated",
+			hashed:      Config{Params: cheap, Peppers: peppers, PepperID: "2024"},
+			current:     Config{Params: cheap, Peppers: peppers, PepperID: "2025"},
+			needsRehash: true,
+		},
+		{
+			name:        "pepper removed",
+			hashed:      Config{Params: cheap, Peppers: peppers, PepperID: "2024"},
+			current:     Config{Params: cheap, Peppers: peppers},
+			needsRehash: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := newHasher(t, tt.hashed).Hash("hunter2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			match, needsRehash, err := newHasher(t, tt.current).Verify("hunter2", encoded, nil)
+			if err != nil || !match {
+				t.Fatalf("got match %t and error %v, want a match", match, err)
+			}
+			if needsRehash != tt.needsRehash {
+				t.Errorf("got needsRehash %t, want %t", needsRehash, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestPepperID(t *testing.T) {
+	peppers := map[string]string{"2024": "old secret", "2025": "new secret"}
+	h := newHasher(t, Config{Params: cheap, Peppers: peppers, PepperID: "2025"})
+
+	encoded, err := h.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(encoded, ",k=2025$") {
+		t.Errorf("got %s, want the pepper ID recorded", encoded)
+	}
+
+	// The hash only verifies with the secret its pepper ID names
+	swapped := map[string]string{"2024": "new secret", "2025": "old secret"}
+	other := newHasher(t, Config{Params: cheap, Peppers: swapped, PepperID: "2025"})
+	if match, _, err := other.Verify("hunter2", encoded, nil); err != nil || match {
+		t.Errorf("got match %t and error %v with another secret, want no match", match, err)
+	}
+	unpeppered := strings.Replace(encoded, ",k=2025", "", 1)
+	if match, _, err := h.Verify("hunter2", unpeppered, nil); err != nil || match {
+		t.Errorf("got match %t and error %v without the pepper, want no match", match, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"zero memory", Config{Params: Params{Iterations: 1, Parallelism: 1}}},
+		{"zero iterations", Config{Params: Params{Memory: 64, Parallelism: 1}}},
+		{"zero parallelism", Config{Params: Params{Memory: 64, Iterations: 1}}},
+		{"pepper ID with a comma", Config{Params: cheap, Peppers: map[string]string{"a,b": "s"}}},
+		{"pepper ID with a dollar", Config{Params: cheap, Peppers: map[string]string{"a$": "s"}}},
+		{"empty pepper", Config{Params: cheap, Peppers: map[string]string{"2024": ""}}},
+		{"unknown pepper ID", Config{Params: cheap, PepperID: "2024"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	h := newHasher(t, Config{Params: cheap})
+	salt := []byte("legacy salt")
+	key, err := scrypt.Key([]byte("hunter2"), salt, 32768, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	tests := []struct {
+		name     string
+		password string
+		salt     []byte
+		match    bool
+		wantErr  error
+	}{
+		{"match", "hunter2", salt, true, nil},
+		{"wrong password", "hunter3", salt, false, nil},
+		{"wrong salt", "hunter2", []byte("other salt"), false, nil},
+		{"missing salt", "hunter2", nil, false, ErrInvalidHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, needsRehash, err := h.Verify(tt.password, encoded, tt.salt)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if match != tt.match {
+				t.Errorf("got match %t, want %t", match, tt.match)
+			}
+			// A legacy hash is always replaced once the password is known
+			if !needsRehash {
+				t.Error("got needsRehash false for a legacy hash")
+			}
+		})
+	}
+}
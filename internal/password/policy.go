@@ -0,0 +1,122 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// Policy rule identifiers, reported in Violation.Rule.
+const (
+	RuleMinLength       = "min_length"
+	RuleMaxLength       = "max_length"
+	RuleStrength        = "strength"
+	RuleContainsAccount = "contains_account"
+	RuleBreached        = "breached"
+)
+
+// minAccountPart is the shortest username or email fragment that passwords
+// are checked for; shorter ones would reject too many reasonable passwords.
+const minAccountPart = 3
+
+// Policy decides which passwords are acceptable.
+type Policy struct {
+	// MinLength and MaxLength count characters, not bytes
+	MinLength int
+	MaxLength int
+	// MinScore is the lowest acceptable zxcvbn score, from 0 (guessable in
+	// seconds) to 4 (very unguessable). 0 disables the check.
+	MinScore int
+	// Breached rejects passwords that appear in known breaches. May be nil.
+	Breached *BreachedList
+}
+
+// Violation is one rule a password failed.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule a password failed.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		rules[i] = v.Rule
+	}
+	return "password violates policy: " + strings.Join(rules, ", ")
+}
+
+// Check validates a password for the account with the given username and
+// email. It returns a *PolicyError listing every failed rule, nil, or any
+// other error if the breached password list could not be read.
+func (p *Policy) Check(password, username, email string) error {
+	var violations []Violation
+	add := func(rule, format string, args ...any) {
+		message := fmt.Sprintf(format, args...)
+		violations = append(violations, Violation{Rule: rule, Message: message})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(RuleMinLength, "Password must be at least %d characters", p.MinLength)
+	}
+	// zxcvbn is slow on long input, so skip the remaining checks when too long
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(RuleMaxLength, "Password must be at most %d characters", p.MaxLength)
+		return &PolicyError{Violations: violations}
+	}
+
+	accountParts := accountParts(username, email)
+	lower := strings.ToLower(password)
+	for _, part := range accountParts {
+		if strings.Contains(lower, part) {
+			add(RuleContainsAccount, "Password must not contain your username or email")
+			break
+		}
+	}
+
+	if p.MinScore > 0 {
+		score := zxcvbn.PasswordStrength(password, accountParts).Score
+		if score < p.MinScore {
+			add(RuleStrength, "Password is too easy to guess")
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return fmt.Errorf("failed to check breached passwords: %w", err)
+		}
+		if breached {
+			add(RuleBreached, "Password has appeared in a data breach")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// accountParts returns the lowercased username, email and email local part
+// that are long enough to be worth checking for.
+func accountParts(username, email string) []string {
+	candidates := []string{username, email}
+	if local, _, ok := strings.Cut(email, "@"); ok {
+		candidates = append(candidates, local)
+	}
+
+	var parts []string
+	for _, c := range candidates {
+		if utf8.RuneCountInString(c) >= minAccountPart {
+			parts = append(parts, strings.ToLower(c))
+		}
+	}
+	return parts
+}
@@ -0,0 +1,189 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// APITokenPrefix marks personal access tokens so they can be told apart from
+// JWTs at a glance and picked up by secret scanners.
+const APITokenPrefix = "cit_"
+
+// ErrAPITokenInvalid is returned for API tokens that are unknown or expired.
+var ErrAPITokenInvalid = errors.New("invalid or expired api token")
+
+// ErrAPITokenNotFound is returned when a user has no API token with the given ID.
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// APIToken is a named, long-lived personal access token. Scopes narrow the
+// owner's permissions; an empty list keeps all of them. A token stops working
+// once the owner's token epoch moves past the one it was created under.
+type APIToken struct {
+	TokenId    int64          `db:"token_id"`
+	UserId     int64          `db:"user_id"`
+	Name       string         `db:"name"`
+	TokenHash  string         `db:"token_hash"`
+	Scopes     string         `db:"scopes"`
+	TokenEpoch int64          `db:"token_epoch"`
+	ExpiresAt  *time.Time     `db:"expires_at"`
+	LastUsedAt *time.Time     `db:"last_used_at"`
+	LastUsedIP sql.NullString `db:"last_used_ip"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+// ScopeList returns the token's scopes.
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// CreateAPIToken issues a personal access token. The token is only returned
+// here; the database keeps its hash.
+func CreateAPIToken(
+	ctx context.Context,
+	db *sqlx.DB,
+	userID int64,
+	name string,
+	scopes []string,
+	expiresAt *time.Time,
+) (*APIToken, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api token: %w", err)
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	var expires any
+	if expiresAt != nil {
+		expires = expiresAt.UTC().Format(time.DateTime)
+	}
+
+	var tokenID int64
+	err := db.GetContext(
+		ctx,
+		&tokenID,
+		`INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at, token_epoch)
+		SELECT ?, ?, ?, ?, ?, token_epoch FROM users WHERE user_id = ?
+		RETURNING token_id`,
+		userID,
+		name,
+		hashAPIToken(token),
+		strings.Join(scopes, " "),
+		expires,
+		userID,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create api token: %w", err)
+	}
+
+	var t APIToken
+	err = db.GetContext(ctx, &t, `SELECT * FROM api_tokens WHERE token_id = ?`, tokenID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get api token: %w", err)
+	}
+	return &t, token, nil
+}
+
+// ListAPITokens returns the user's API tokens, newest first. Tokens ended by
+// a token epoch bump are left out.
+func ListAPITokens(ctx context.Context, db *sqlx.DB, userID int64) ([]APIToken, error) {
+	tokens := []APIToken{}
+	err := db.SelectContext(
+		ctx,
+		&tokens,
+		`SELECT t.* FROM api_tokens t JOIN users u ON u.user_id = t.user_id
+		WHERE t.user_id = ? AND t.token_epoch >= u.token_epoch
+		ORDER BY t.created_at DESC, t.token_id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// DeleteAPIToken revokes one of the user's API tokens.
+func DeleteAPIToken(ctx context.Context, db *sqlx.DB, userID, tokenID int64) error {
+	result, err := db.ExecContext(
+		ctx,
+		`DELETE FROM api_tokens WHERE token_id = ? AND user_id = ?`,
+		tokenID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete api token: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete api token: %w", err)
+	}
+	if rows == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// LookupAPIToken resolves a presented API token without recording its use.
+func LookupAPIToken(ctx context.Context, db *sqlx.DB, token string) (*APIToken, error) {
+	var t APIToken
+	err := db.GetContext(
+		ctx,
+		&t,
+		`SELECT * FROM api_tokens
+		WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`,
+		hashAPIToken(token),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPITokenInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api token: %w", err)
+	}
+	return &t, nil
+}
+
+// AuthenticateAPIToken resolves a presented API token and records where it
+// was used from. Last-used is only written when the IP changes or a minute
+// has passed, so busy scripts do not turn every request into a write.
+func AuthenticateAPIToken(
+	ctx context.Context,
+	db *sqlx.DB,
+	token string,
+	ip string,
+) (*APIToken, error) {
+	t, err := LookupAPIToken(ctx, db, token)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.ExecContext(
+		ctx,
+		`UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = ?
+		WHERE token_id = ? AND (
+			last_used_at IS NULL
+			OR last_used_at < datetime('now', '-60 seconds')
+			OR last_used_ip IS NOT ?
+		)`,
+		ip,
+		t.TokenId,
+		ip,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record api token use: %w", err)
+	}
+	return t, nil
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,27 +2,31 @@ package user
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/subtle"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"time"
 
+	"citadel/internal/password"
+
 	"github.com/jmoiron/sqlx"
 	"github.com/mattn/go-sqlite3"
-	"golang.org/x/crypto/scrypt"
 )
 
+// User is a row of the users table. Hash is a PHC string produced by
+// password.Hasher; Salt is only set for legacy scrypt hashes that predate it.
 type User struct {
-	UserId    int64      `db:"user_id"       json:"user_id"`
-	Username  string     `db:"username"      json:"username"`
-	Email     string     `db:"email"         json:"email"`
-	Hash      string     `db:"password_hash" json:"-"`
-	Salt      []byte     `db:"salt"          json:"-"`
-	LastLogin *time.Time `db:"last_login"    json:"last_login,omitempty"`
-	CreatedAt time.Time  `db:"created_at"    json:"created_at"`
-	UpdatedAt time.Time  `db:"updated_at"    json:"updated_at"`
+	UserId          int64      `db:"user_id"           json:"user_id"`
+	Username        string     `db:"username"          json:"username"`
+	Email           string     `db:"email"             json:"email"`
+	Hash            string     `db:"password_hash"     json:"-"`
+	Salt            []byte     `db:"salt"              json:"-"`
+	LastLogin       *time.Time `db:"last_login"        json:"last_login,omitempty"`
+	CreatedAt       time.Time  `db:"created_at"        json:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at"        json:"updated_at"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at" json:"email_verified_at,omitempty"`
+	TokenEpoch      int64      `db:"token_epoch"       json:"-"`
+	SuspendedAt     *time.Time `db:"suspended_at"      json:"suspended_at,omitempty"`
+	DeletedAt       *time.Time `db:"deleted_at"        json:"-"`
 }
 
 type CreateRequest struct {
@@ -31,18 +35,29 @@ type CreateRequest struct {
 	Password string `json:"password"`
 }
 
-func Create(ctx context.Context, db *sqlx.DB, request CreateRequest) (int64, error) {
-	h, s, err := hash(request.Password, nil)
+func Create(
+	ctx context.Context,
+	db *sqlx.DB,
+	hasher *password.Hasher,
+	request CreateRequest,
+) (int64, error) {
+	h, err := hasher.Hash(request.Password)
 	if err != nil {
 		return 0, fmt.Errorf("failed to hash password: %w", err)
 	}
-	result, err := db.ExecContext(
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
 		ctx,
 		`INSERT INTO users (username, email, password_hash, salt) VALUES (?, ?, ?, ?)`,
 		request.Username,
 		request.Email,
 		h,
-		s,
+		[]byte{},
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create user: %w", err)
@@ -51,34 +66,45 @@ func Create(ctx context.Context, db *sqlx.DB, request CreateRequest) (int64, err
 	if err != nil {
 		return 0, fmt.Errorf("failed to get last insert id: %w", err)
 	}
-	return uid, nil
-}
 
-func Verify(password string, storedHash string, salt []byte) (bool, error) {
-	computed, _, err := hash(password, salt)
+	// Every account starts with the default self-service role
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO user_roles (user_id, role_id)
+		SELECT ?, role_id FROM roles WHERE name = 'user'`,
+		uid,
+	)
 	if err != nil {
-		return false, fmt.Errorf("failed to compute hash: %w", err)
+		return 0, fmt.Errorf("failed to assign default role: %w", err)
 	}
-	match := subtle.ConstantTimeCompare([]byte(computed), []byte(storedHash)) == 1
-	return match, nil
-}
 
-// hash uses scrypt with OWASP 2024 recommended parameters.
-// N=32768 (2^15), r=8, p=1, keyLen=32
-// See: https://cheatsheetseries.owasp.org/cheatsheets/Password_Storage_Cheat_Sheet.html
-func hash(password string, salt []byte) (string, []byte, error) {
-	if salt == nil {
-		salt = make([]byte, 32)
-		if _, err := rand.Read(salt); err != nil {
-			return "", nil, fmt.Errorf("failed to generate salt: %w", err)
-		}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit user: %w", err)
 	}
+	return uid, nil
+}
 
-	hash, err := scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
+// SetPasswordHash replaces a password hash that was verified as current,
+// e.g. to upgrade it to newer parameters after a login. It does nothing if
+// the password changed in the meantime.
+func SetPasswordHash(
+	ctx context.Context,
+	db *sqlx.DB,
+	userID int64,
+	oldHash, newHash string,
+) error {
+	_, err := db.ExecContext(
+		ctx,
+		`UPDATE users SET password_hash = ?, salt = X''
+		WHERE user_id = ? AND password_hash = ?`,
+		newHash,
+		userID,
+		oldHash,
+	)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create key: %w", err)
+		return fmt.Errorf("failed to update password hash: %w", err)
 	}
-	return base64.StdEncoding.EncodeToString(hash), salt, nil
+	return nil
 }
 
 // IsConflict returns true if the error is a SQLite unique constraint violation.
@@ -0,0 +1,149 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNotDeleted is returned when restoring an account that is not pending
+// deletion.
+var ErrNotDeleted = errors.New("user not pending deletion")
+
+// Delete soft-deletes the user and returns the new token epoch. The account
+// stops resolving, its username and email are released for reuse and its
+// access tokens are invalidated. The row is kept until it is purged after
+// purgeAfter, so the deletion can be undone with Restore until then.
+func Delete(ctx context.Context, db *sqlx.DB, userID int64, purgeAfter time.Time) (int64, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO deleted_users (user_id, username, email, purge_after)
+		SELECT user_id, username, email, ? FROM users
+		WHERE user_id = ? AND deleted_at IS NULL`,
+		purgeAfter.UTC(),
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record deleted user: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return 0, ErrNotFound
+	}
+
+	// The placeholders are random so they can never collide with a real account
+	var epoch int64
+	err = tx.GetContext(
+		ctx,
+		&epoch,
+		`UPDATE users SET
+			username = 'deleted:' || user_id || ':' || lower(hex(randomblob(8))),
+			email = 'deleted:' || user_id || ':' || lower(hex(randomblob(8))),
+			deleted_at = CURRENT_TIMESTAMP,
+			token_epoch = token_epoch + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?
+		RETURNING token_epoch`,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete password resets: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+	return epoch, nil
+}
+
+// Restore undoes a deletion that has not been purged yet. It fails with a
+// unique constraint error, see IsConflict, if the username or email has
+// been taken since.
+func Restore(ctx context.Context, db *sqlx.DB, userID int64) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deleted struct {
+		Username string `db:"username"`
+		Email    string `db:"email"`
+	}
+	err = tx.GetContext(
+		ctx,
+		&deleted,
+		`SELECT username, email FROM deleted_users WHERE user_id = ?`,
+		userID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotDeleted
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get deleted user: %w", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE users SET
+			username = ?,
+			email = ?,
+			deleted_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?`,
+		deleted.Username,
+		deleted.Email,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM deleted_users WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear deleted user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user restore: %w", err)
+	}
+	return nil
+}
+
+// PurgeDeleted permanently removes deleted accounts whose grace period ended
+// before now, along with everything that belongs to them, and returns their
+// IDs. User IDs are never reused, so audit events naming them stay accurate.
+func PurgeDeleted(ctx context.Context, db *sqlx.DB, now time.Time) ([]int64, error) {
+	var userIDs []int64
+	err := db.SelectContext(
+		ctx,
+		&userIDs,
+		`DELETE FROM users WHERE user_id IN (
+			SELECT user_id FROM deleted_users WHERE purge_after <= ?
+		)
+		RETURNING user_id`,
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge deleted users: %w", err)
+	}
+	return userIDs, nil
+}
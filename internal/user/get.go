@@ -9,7 +9,12 @@ import (
 
 func ByEmail(ctx context.Context, db *sqlx.DB, email string) (*User, error) {
 	var u User
-	err := db.GetContext(ctx, &u, `SELECT * FROM users WHERE email = ?`, email)
+	err := db.GetContext(
+		ctx,
+		&u,
+		`SELECT * FROM users WHERE email = ? AND deleted_at IS NULL`,
+		email,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
@@ -18,7 +23,12 @@ func ByEmail(ctx context.Context, db *sqlx.DB, email string) (*User, error) {
 
 func ByID(ctx context.Context, db *sqlx.DB, userID int64) (*User, error) {
 	var u User
-	err := db.GetContext(ctx, &u, `SELECT * FROM users WHERE user_id = ?`, userID)
+	err := db.GetContext(
+		ctx,
+		&u,
+		`SELECT * FROM users WHERE user_id = ? AND deleted_at IS NULL`,
+		userID,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user by ID: %w", err)
 	}
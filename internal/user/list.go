@@ -2,16 +2,200 @@ package user
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
-func List(ctx context.Context, db *sqlx.DB) ([]User, error) {
-	var users []User
-	err := db.SelectContext(ctx, &users, `SELECT * FROM users ORDER BY created_at DESC`)
+// ErrInvalidCursor is returned for a cursor that is malformed or was issued
+// for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// sortKeys maps the columns List can sort on to the expression ordered by.
+// Users who never logged in sort before everyone else, as if NULL were the
+// empty string, so the cursor can always compare against a value. The
+// expressions match the users indexes.
+var sortKeys = map[string]string{
+	"created_at": "created_at",
+	"last_login": "COALESCE(last_login, '')",
+	"username":   "username",
+	"email":      "email",
+}
+
+// SortColumn reports whether List can sort on the column.
+func SortColumn(column string) bool {
+	_, ok := sortKeys[column]
+	return ok
+}
+
+// ListFilter narrows and orders a List query. Zero fields match everything.
+// Prefixes are matched case-insensitively.
+type ListFilter struct {
+	CreatedSince   *time.Time
+	CreatedUntil   *time.Time
+	LastLoginSince *time.Time
+	LastLoginUntil *time.Time
+	UsernamePrefix string
+	EmailPrefix    string
+	// Sort is a column accepted by SortColumn, created_at if empty. Ties are
+	// broken by user ID
+	Sort       string
+	Descending bool
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	Limit  int
+	// WithTotal counts every matching user, ignoring Cursor and Limit
+	WithTotal bool
+}
+
+// Page is one page of users. NextCursor is empty on the last page.
+type Page struct {
+	Users      []User
+	NextCursor string
+	Total      *int64
+}
+
+// cursor is where a page ended. It is only valid for the sort it was issued
+// for.
+type cursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Key        string `json:"k"`
+	UserID     int64  `json:"u"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// List returns a page of users that are not deleted.
+func List(ctx context.Context, db *sqlx.DB, f ListFilter) (*Page, error) {
+	if f.Sort == "" {
+		f.Sort = "created_at"
+	}
+	key, ok := sortKeys[f.Sort]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort users by %s", f.Sort)
+	}
+
+	where := []string{"deleted_at IS NULL"}
+	args := []any{}
+
+	if f.CreatedSince != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.CreatedSince.UTC().Format(time.DateTime))
+	}
+	if f.CreatedUntil != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, f.CreatedUntil.UTC().Format(time.DateTime))
+	}
+	if f.LastLoginSince != nil {
+		where = append(where, "last_login >= ?")
+		args = append(args, f.LastLoginSince.UTC().Format(time.DateTime))
+	}
+	if f.LastLoginUntil != nil {
+		where = append(where, "last_login < ?")
+		args = append(args, f.LastLoginUntil.UTC().Format(time.DateTime))
+	}
+	if f.UsernamePrefix != "" {
+		where = append(where, `username LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(f.UsernamePrefix)+"%")
+	}
+	if f.EmailPrefix != "" {
+		where = append(where, `email LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(f.EmailPrefix)+"%")
+	}
+
+	page := &Page{Users: []User{}}
+	if f.WithTotal {
+		var total int64
+		err := db.GetContext(
+			ctx,
+			&total,
+			`SELECT COUNT(*) FROM users WHERE `+strings.Join(where, " AND "),
+			args...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count users: %w", err)
+		}
+		page.Total = &total
+	}
+
+	order, compare := "ASC", ">"
+	if f.Descending {
+		order, compare = "DESC", "<"
+	}
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != f.Sort || c.Descending != f.Descending {
+			return nil, ErrInvalidCursor
+		}
+		where = append(where, fmt.Sprintf(
+			"(%[1]s %[2]s ? OR (%[1]s = ? AND user_id %[2]s ?))",
+			key,
+			compare,
+		))
+		args = append(args, c.Key, c.Key, c.UserID)
+	}
+
+	// Fetch one extra user to know whether there is another page
+	query := fmt.Sprintf(
+		`SELECT users.*, CAST(%[1]s AS TEXT) AS sort_key FROM users
+		WHERE %[2]s
+		ORDER BY %[1]s %[3]s, user_id %[3]s
+		LIMIT ?`,
+		key,
+		strings.Join(where, " AND "),
+		order,
+	)
+	args = append(args, f.Limit+1)
+
+	var rows []struct {
+		User
+		SortKey string `db:"sort_key"`
+	}
+	if err := db.SelectContext(ctx, &rows, query, args...); err != nil {
 		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
-	return users, nil
+
+	if len(rows) > f.Limit && f.Limit > 0 {
+		rows = rows[:f.Limit]
+		last := rows[len(rows)-1]
+		page.NextCursor = cursor{
+			Sort:       f.Sort,
+			Descending: f.Descending,
+			Key:        last.SortKey,
+			UserID:     last.UserId,
+		}.encode()
+	}
+	for _, row := range rows {
+		page.Users = append(page.Users, row.User)
+	}
+	return page, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s, so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
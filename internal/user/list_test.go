@@ -0,0 +1,232 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"citadel/internal/database"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// newListDB returns a database holding these users, by ID:
+//
+//	1 alice    alice@example.com    created 01-01  last login 01-05
+//	2 Bob      bob@example.com      created 01-02  never logged in
+//	3 carol    Carol@Example.org    created 01-02  last login 01-03
+//	4 a_b      ab@example.com       created 01-04  last login 01-07
+//	5 alfred   alfred@example.org   created 01-05  never logged in
+//	6 deleted  deleted@example.com  created 01-06  last login 01-06, deleted
+//	7 dave     dave@example.com     created 01-07  last login 01-03
+func newListDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "citadel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	users := []struct {
+		username, email, createdAt string
+		lastLogin                  *string
+	}{
+		{"alice", "alice@example.com", "2026-01-01 09:00:00", ptr("2026-01-05 12:00:00")},
+		{"Bob", "bob@example.com", "2026-01-02 09:00:00", nil},
+		{"carol", "Carol@Example.org", "2026-01-02 09:00:00", ptr("2026-01-03 12:00:00")},
+		{"a_b", "ab@example.com", "2026-01-04 09:00:00", ptr("2026-01-07 12:00:00")},
+		{"alfred", "alfred@example.org", "2026-01-05 09:00:00", nil},
+		{"deleted", "deleted@example.com", "2026-01-06 09:00:00", ptr("2026-01-06 12:00:00")},
+		{"dave", "dave@example.com", "2026-01-07 09:00:00", ptr("2026-01-03 12:00:00")},
+	}
+	for _, u := range users {
+		_, err := db.Exec(
+			`INSERT INTO users (username, email, password_hash, salt, created_at, last_login)
+			VALUES (?, ?, '', X'', ?, ?)`,
+			u.username,
+			u.email,
+			u.createdAt,
+			u.lastLogin,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec(`UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE user_id = 6`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func date(day int) *time.Time {
+	return ptr(time.Date(2026, time.January, day, 0, 0, 0, 0, time.UTC))
+}
+
+func userIDs(users []User) []int64 {
+	ids := []int64{}
+	for _, u := range users {
+		ids = append(ids, u.UserId)
+	}
+	return ids
+}
+
+func TestListFilters(t *testing.T) {
+	db := newListDB(t)
+
+	tests := []struct {
+		name   string
+		filter ListFilter
+		want   []int64
+	}{
+		{"none", ListFilter{}, []int64{1, 2, 3, 4, 5, 7}},
+		{"created since", ListFilter{CreatedSince: date(4)}, []int64{4, 5, 7}},
+		{"created until", ListFilter{CreatedUntil: date(4)}, []int64{1, 2, 3}},
+		{
+			"created range",
+			ListFilter{CreatedSince: date(2), CreatedUntil: date(5)},
+			[]int64{2, 3, 4},
+		},
+		{"last login since", ListFilter{LastLoginSince: date(5)}, []int64{1, 4}},
+		{"last login until", ListFilter{LastLoginUntil: date(5)}, []int64{3, 7}},
+		{
+			"last login range",
+			ListFilter{LastLoginSince: date(3), LastLoginUntil: date(6)},
+			[]int64{1, 3, 7},
+		},
+		{"username prefix", ListFilter{UsernamePrefix: "al"}, []int64{1, 5}},
+		{"username prefix ignores case", ListFilter{UsernamePrefix: "b"}, []int64{2}},
+		{"username prefix is literal", ListFilter{UsernamePrefix: "a_"}, []int64{4}},
+		{"username prefix wildcard", ListFilter{UsernamePrefix: "%"}, []int64{}},
+		{"email prefix", ListFilter{EmailPrefix: "CAROL@"}, []int64{3}},
+		{"deleted users are hidden", ListFilter{UsernamePrefix: "deleted"}, []int64{}},
+		{
+			"combined",
+			ListFilter{EmailPrefix: "a", LastLoginSince: date(1)},
+			[]int64{1, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Limit = 100
+			tt.filter.WithTotal = true
+			page, err := List(context.Background(), db, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := userIDs(page.Users)
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got users %v, want %v", got, tt.want)
+			}
+			if *page.Total != int64(len(tt.want)) {
+				t.Errorf("got total %d, want %d", *page.Total, len(tt.want))
+			}
+			if page.NextCursor != "" {
+				t.Errorf("got a next cursor on the only page")
+			}
+		})
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	db := newListDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		sort       string
+		descending bool
+		want       []int64
+	}{
+		{"created_at", false, []int64{1, 2, 3, 4, 5, 7}},
+		{"created_at", true, []int64{7, 5, 4, 3, 2, 1}},
+		// Users who never logged in sort first
+		{"last_login", false, []int64{2, 5, 3, 7, 1, 4}},
+		{"last_login", true, []int64{4, 1, 7, 3, 5, 2}},
+		{"username", false, []int64{2, 4, 5, 1, 3, 7}},
+		{"email", true, []int64{7, 2, 1, 5, 4, 3}},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 2, 4, 6} {
+			filter := ListFilter{Sort: tt.sort, Descending: tt.descending, Limit: limit}
+
+			var got []int64
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatalf("%s descending=%t limit=%d: cursor never ran out",
+						tt.sort, tt.descending, limit)
+				}
+				page, err := List(ctx, db, filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page.Users) > limit {
+					t.Fatalf("got %d users, want at most %d", len(page.Users), limit)
+				}
+				got = append(got, userIDs(page.Users)...)
+				if page.NextCursor == "" {
+					break
+				}
+				filter.Cursor = page.NextCursor
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s descending=%t limit=%d: got %v, want %v",
+					tt.sort, tt.descending, limit, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestListCursorRejected(t *testing.T) {
+	db := newListDB(t)
+	ctx := context.Background()
+
+	first, err := List(ctx, db, ListFilter{Sort: "created_at", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter ListFilter
+	}{
+		{"not base64", ListFilter{Cursor: "!!"}},
+		{"not json", ListFilter{Cursor: "bm90IGpzb24"}},
+		{"other sort", ListFilter{Sort: "username", Cursor: first.NextCursor}},
+		{"other order", ListFilter{Descending: true, Cursor: first.NextCursor}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Limit = 2
+			if _, err := List(ctx, db, tt.filter); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestRecordLogin(t *testing.T) {
+	db := newListDB(t)
+	ctx := context.Background()
+
+	before := time.Now().Add(-time.Minute)
+	if err := RecordLogin(ctx, db, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := List(ctx, db, ListFilter{LastLoginSince: &before, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := userIDs(page.Users); !slices.Equal(got, []int64{2}) {
+		t.Errorf("got users %v logged in since %v, want [2]", got, before)
+	}
+}
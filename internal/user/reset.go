@@ -0,0 +1,151 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"citadel/internal/password"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrResetInvalid is returned for reset tokens that are unknown, used or expired.
+var ErrResetInvalid = errors.New("invalid or expired reset token")
+
+// CreateReset issues a single-use password reset token. Only its hash is
+// stored, and any earlier unused token for the user stops working.
+func CreateReset(
+	ctx context.Context,
+	db *sqlx.DB,
+	userID int64,
+	ttl time.Duration,
+) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND used_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to invalidate previous reset tokens: %w", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO password_resets (user_id, token_hash, expires_at)
+		VALUES (?, ?, datetime('now', ?))`,
+		userID,
+		hashResetToken(token),
+		fmt.Sprintf("+%d seconds", int64(ttl.Seconds())),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit reset token: %w", err)
+	}
+	return token, nil
+}
+
+// ResetUser returns the user a reset token belongs to without using it up, so
+// the new password can be checked against the account first.
+func ResetUser(ctx context.Context, db *sqlx.DB, token string) (*User, error) {
+	var u User
+	err := db.GetContext(
+		ctx,
+		&u,
+		`SELECT users.* FROM password_resets
+		JOIN users ON users.user_id = password_resets.user_id
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+			AND users.deleted_at IS NULL`,
+		hashResetToken(token),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrResetInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up reset token: %w", err)
+	}
+	return &u, nil
+}
+
+// ConsumeReset uses a reset token to set a new password and returns the user
+// it belonged to. Completing a reset also proves ownership of the address.
+func ConsumeReset(
+	ctx context.Context,
+	db *sqlx.DB,
+	hasher *password.Hasher,
+	token, newPassword string,
+) (int64, error) {
+	h, err := hasher.Hash(newPassword)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.GetContext(
+		ctx,
+		&userID,
+		`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id`,
+		hashResetToken(token),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrResetInvalid
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to consume reset token: %w", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE users SET
+			password_hash = ?,
+			salt = X'',
+			email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?`,
+		h,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit password reset: %w", err)
+	}
+	return userID, nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
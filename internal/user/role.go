@@ -0,0 +1,97 @@
+package user
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Roles returns the names of the roles granted to a user.
+func Roles(ctx context.Context, db *sqlx.DB, userID int64) ([]string, error) {
+	roles := []string{}
+	err := db.SelectContext(
+		ctx,
+		&roles,
+		`SELECT r.name FROM roles r
+		JOIN user_roles ur ON ur.role_id = r.role_id
+		WHERE ur.user_id = ?
+		ORDER BY r.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	return roles, nil
+}
+
+// Permissions returns the union of the permissions granted by a user's roles.
+func Permissions(ctx context.Context, db *sqlx.DB, userID int64) ([]string, error) {
+	permissions := []string{}
+	err := db.SelectContext(
+		ctx,
+		&permissions,
+		`SELECT DISTINCT p.name FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.permission_id
+		JOIN user_roles ur ON ur.role_id = rp.role_id
+		WHERE ur.user_id = ?
+		ORDER BY p.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// GrantRole gives a user a role. Granting a role the user already has is a no-op.
+func GrantRole(ctx context.Context, db *sqlx.DB, userID int64, role string) error {
+	result, err := db.ExecContext(
+		ctx,
+		`INSERT OR IGNORE INTO user_roles (user_id, role_id)
+		SELECT ?, role_id FROM roles WHERE name = ?`,
+		userID,
+		role,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to grant role: %w", err)
+	}
+
+	// Zero rows is ambiguous between an unknown role and an existing grant
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		var exists bool
+		err := db.GetContext(
+			ctx,
+			&exists,
+			`SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)`,
+			role,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to look up role: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("role not found")
+		}
+	}
+
+	return nil
+}
+
+// RevokeRole removes a role from a user and reports whether the user held it.
+func RevokeRole(ctx context.Context, db *sqlx.DB, userID int64, role string) (bool, error) {
+	result, err := db.ExecContext(
+		ctx,
+		`DELETE FROM user_roles
+		WHERE user_id = ? AND role_id = (SELECT role_id FROM roles WHERE name = ?)`,
+		userID,
+		role,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	return rows > 0, nil
+}
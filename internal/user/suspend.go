@@ -0,0 +1,93 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNotFound is returned when no user has the given ID.
+var ErrNotFound = errors.New("user not found")
+
+// TokenEpoch returns the user's token epoch. Access tokens carry the epoch
+// they were issued in and are rejected once it has moved on.
+func TokenEpoch(ctx context.Context, db *sqlx.DB, userID int64) (int64, error) {
+	var epoch int64
+	err := db.GetContext(ctx, &epoch, `SELECT token_epoch FROM users WHERE user_id = ?`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get token epoch: %w", err)
+	}
+	return epoch, nil
+}
+
+// BumpTokenEpoch invalidates every access token issued to the user so far
+// and returns the new epoch.
+func BumpTokenEpoch(ctx context.Context, db *sqlx.DB, userID int64) (int64, error) {
+	var epoch int64
+	err := db.GetContext(
+		ctx,
+		&epoch,
+		`UPDATE users SET token_epoch = token_epoch + 1 WHERE user_id = ?
+		RETURNING token_epoch`,
+		userID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to bump token epoch: %w", err)
+	}
+	return epoch, nil
+}
+
+// Suspend blocks the user from signing in and invalidates their access tokens,
+// returning the new token epoch. Suspending a suspended user only bumps the
+// epoch again.
+func Suspend(ctx context.Context, db *sqlx.DB, userID int64) (int64, error) {
+	var epoch int64
+	err := db.GetContext(
+		ctx,
+		&epoch,
+		`UPDATE users SET
+			suspended_at = COALESCE(suspended_at, CURRENT_TIMESTAMP),
+			token_epoch = token_epoch + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?
+		RETURNING token_epoch`,
+		userID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to suspend user: %w", err)
+	}
+	return epoch, nil
+}
+
+// Unsuspend lets a suspended user sign in again.
+func Unsuspend(ctx context.Context, db *sqlx.DB, userID int64) error {
+	result, err := db.ExecContext(
+		ctx,
+		`UPDATE users SET suspended_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unsuspend user: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
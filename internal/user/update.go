@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 
+	"citadel/internal/password"
+
 	"github.com/jmoiron/sqlx"
 )
 
@@ -14,7 +16,13 @@ type UpdateRequest struct {
 	Password *string `json:"password,omitempty"`
 }
 
-func Update(ctx context.Context, db *sqlx.DB, userID int64, request UpdateRequest) error {
+func Update(
+	ctx context.Context,
+	db *sqlx.DB,
+	hasher *password.Hasher,
+	userID int64,
+	request UpdateRequest,
+) error {
 	updates := []string{}
 	args := []interface{}{}
 
@@ -26,17 +34,22 @@ func Update(ctx context.Context, db *sqlx.DB, userID int64, request UpdateReques
 	if request.Email != nil {
 		updates = append(updates, "email = ?")
 		args = append(args, *request.Email)
+		// A new address has to be verified again
+		updates = append(
+			updates,
+			"email_verified_at = CASE WHEN email = ? THEN email_verified_at END",
+		)
+		args = append(args, *request.Email)
 	}
 
 	if request.Password != nil {
-		h, s, err := hash(*request.Password, nil)
+		h, err := hasher.Hash(*request.Password)
 		if err != nil {
 			return fmt.Errorf("failed to hash password: %w", err)
 		}
 		updates = append(updates, "password_hash = ?")
 		args = append(args, h)
-		updates = append(updates, "salt = ?")
-		args = append(args, s)
+		updates = append(updates, "salt = X''")
 	}
 
 	if len(updates) == 0 {
@@ -50,7 +63,7 @@ func Update(ctx context.Context, db *sqlx.DB, userID int64, request UpdateReques
 	args = append(args, userID)
 
 	query := fmt.Sprintf(
-		"UPDATE users SET %s WHERE user_id = ?",
+		"UPDATE users SET %s WHERE user_id = ? AND deleted_at IS NULL",
 		strings.Join(updates, ", "),
 	)
 
@@ -70,3 +83,17 @@ func Update(ctx context.Context, db *sqlx.DB, userID int64, request UpdateReques
 
 	return nil
 }
+
+// RecordLogin sets the user's last login to now. It is stored in the same
+// format as created_at, so ListFilter can compare the two alike.
+func RecordLogin(ctx context.Context, db *sqlx.DB, userID int64) error {
+	_, err := db.ExecContext(
+		ctx,
+		`UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE user_id = ?`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record login: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package user
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MarkEmailVerified records that the user proved ownership of the address.
+// The address is checked so a link sent before an email change cannot verify
+// the new address.
+func MarkEmailVerified(ctx context.Context, db *sqlx.DB, userID int64, email string) error {
+	result, err := db.ExecContext(
+		ctx,
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE user_id = ? AND email = ?`,
+		userID,
+		email,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
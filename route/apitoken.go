@@ -0,0 +1,238 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// apiTokenResponse is how an API token is shown to its owner. The token
+// itself is only ever returned once, at creation.
+type apiTokenResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func newAPITokenResponse(t *user.APIToken) apiTokenResponse {
+	return apiTokenResponse{
+		ID:         t.TokenId,
+		Name:       t.Name,
+		Scopes:     t.ScopeList(),
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		LastUsedIP: t.LastUsedIP.String,
+		CreatedAt:  t.CreatedAt,
+	}
+}
+
+func ListAPITokens(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("list api tokens handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("list api tokens failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		tokens, err := user.ListAPITokens(r.Context(), db, claims.UserId)
+		if err != nil {
+			log.Error("failed to list api tokens", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list API tokens"})
+			return
+		}
+
+		response := make([]apiTokenResponse, len(tokens))
+		for i := range tokens {
+			response[i] = newAPITokenResponse(&tokens[i])
+		}
+
+		log.Info("list api tokens handler completed successfully", "count", len(response))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func CreateAPIToken(db *sqlx.DB) http.HandlerFunc {
+	type Request struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("create api token handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("create api token failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		// A scoped token must not be able to mint itself a broader one
+		if claims.APITokenID != 0 {
+			log.Warn("create api token failed: authenticated with an api token")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "API tokens cannot create API tokens"})
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("create api token failed: invalid request body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		if req.Name == "" {
+			log.Warn("create api token validation failed: missing name")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Name is required"})
+			return
+		}
+
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			log.Warn("create api token validation failed: expiry in the past")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Expiry must be in the future"})
+			return
+		}
+
+		// Scopes are limited to what the calling token was granted
+		for _, scope := range req.Scopes {
+			if !claims.HasScope(scope) {
+				log.Warn("create api token validation failed: scope not held", "scope", scope)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).
+					Encode(map[string]string{"error": "Unknown or unavailable scope: " + scope})
+				return
+			}
+		}
+		// An empty list would keep all of the owner's permissions, so a token
+		// created without scopes gets the caller's instead
+		scopes := req.Scopes
+		if len(scopes) == 0 {
+			scopes = strings.Fields(claims.Scope)
+		}
+		slices.Sort(scopes)
+		scopes = slices.Compact(scopes)
+
+		t, token, err := user.CreateAPIToken(
+			r.Context(),
+			db,
+			claims.UserId,
+			req.Name,
+			scopes,
+			req.ExpiresAt,
+		)
+		if err != nil {
+			log.Error("failed to create api token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create API token"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventAPITokenCreate, claims.UserId, claims.UserId, map[string]any{
+			"api_token_id": t.TokenId,
+			"scopes":       scopes,
+		})
+
+		log.Info(
+			"create api token handler completed successfully",
+			"user_id", claims.UserId,
+			"token_id", t.TokenId,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			apiTokenResponse
+			Token string `json:"token"`
+		}{newAPITokenResponse(t), token})
+	}
+}
+
+func DeleteAPIToken(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("delete api token handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("delete api token failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		tokenID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("delete api token validation failed: invalid ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token ID"})
+			return
+		}
+
+		err = user.DeleteAPIToken(r.Context(), db, claims.UserId, tokenID)
+		if errors.Is(err, user.ErrAPITokenNotFound) {
+			log.Warn("delete api token failed: token not found", "token_id", tokenID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "API token not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete api token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete API token"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventTokenRevoke, claims.UserId, claims.UserId, map[string]any{
+			"api_token_id": tokenID,
+		})
+
+		log.Info(
+			"delete api token handler completed successfully",
+			"user_id", claims.UserId,
+			"token_id", tokenID,
+		)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
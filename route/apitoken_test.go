@@ -0,0 +1,84 @@
+package route
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestCreateAPITokenScope(t *testing.T) {
+	tests := []struct {
+		name string
+		// scope narrows the session that creates the token
+		scope string
+		// scopes are requested for the token
+		scopes     []string
+		wantStatus int
+		wantScopes []string
+		// usersStatus is what the token gets listing users
+		usersStatus int
+	}{
+		{
+			name:       "full session without scopes",
+			wantStatus: http.StatusCreated,
+			wantScopes: []string{
+				"account",
+				"audit:read",
+				"clients:manage",
+				"logs:stream",
+				"roles:manage",
+				"users:impersonate",
+				"users:read",
+				"users:write",
+			},
+			usersStatus: http.StatusOK,
+		},
+		{
+			name:        "full session with a narrower scope",
+			scopes:      []string{"account"},
+			wantStatus:  http.StatusCreated,
+			wantScopes:  []string{"account"},
+			usersStatus: http.StatusForbidden,
+		},
+		{
+			name:        "narrowed session without scopes",
+			scope:       "account",
+			wantStatus:  http.StatusCreated,
+			wantScopes:  []string{"account"},
+			usersStatus: http.StatusForbidden,
+		},
+		{
+			name:       "narrowed session with a broader scope",
+			scope:      "account",
+			scopes:     []string{"account", "users:write"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			registerUser(t, s, "alice", "alice@example.com")
+			grantRole(t, s, "alice", "admin")
+			session := login(t, s, "alice@example.com", tt.scope)
+
+			var created struct {
+				Scopes []string `json:"scopes"`
+				Token  string   `json:"token"`
+			}
+			body := map[string]any{"name": "ci", "scopes": tt.scopes}
+			status := s.do(t, "POST", "/me/tokens", session.AccessToken, body, &created)
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", status, tt.wantStatus)
+			}
+			if status != http.StatusCreated {
+				return
+			}
+			if !slices.Equal(created.Scopes, tt.wantScopes) {
+				t.Errorf("got scopes %v, want %v", created.Scopes, tt.wantScopes)
+			}
+			if got := s.do(t, "GET", "/users", created.Token, nil, nil); got != tt.usersStatus {
+				t.Errorf("got status %d listing users, want %d", got, tt.usersStatus)
+			}
+		})
+	}
+}
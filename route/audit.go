@@ -0,0 +1,160 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/middleware"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// recordAudit appends an event to the audit log with the request's client
+// details and, for impersonated requests, the admin behind them. Failing to
+// record is logged but does not fail the request.
+func recordAudit(
+	r *http.Request,
+	db *sqlx.DB,
+	eventType string,
+	actorID, targetID int64,
+	details map[string]any,
+) {
+	e := audit.Event{
+		Type:      eventType,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: middleware.GetRequestID(r),
+	}
+	if actorID != 0 {
+		e.ActorID = audit.ID(actorID)
+	}
+	if targetID != 0 {
+		e.TargetID = audit.ID(targetID)
+	}
+	if claims, ok := middleware.GetClaims(r); ok && claims.Impersonated() {
+		e.ImpersonatorID = audit.ID(claims.Actor.UserId)
+	}
+	if err := audit.Record(r.Context(), db, e, details); err != nil {
+		middleware.GetLogger(r).Error("failed to record audit event",
+			"error", err,
+			"event_type", eventType,
+		)
+	}
+}
+
+// actorID returns the authenticated user making the request, or 0.
+func actorID(r *http.Request) int64 {
+	if claims, ok := middleware.GetClaims(r); ok {
+		return claims.UserId
+	}
+	return 0
+}
+
+func ListAudit(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("list audit handler started")
+
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			log.Warn("list audit validation failed", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		// Fetch one extra event to know whether there is another page
+		limit := filter.Limit
+		filter.Limit++
+		events, err := audit.List(r.Context(), db, filter)
+		if err != nil {
+			log.Error("failed to list audit events", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list audit events"})
+			return
+		}
+
+		response := map[string]any{"events": events}
+		if len(events) > limit {
+			events = events[:limit]
+			response["events"] = events
+			response["next_cursor"] = strconv.FormatInt(events[limit-1].ID, 10)
+		}
+
+		log.Info("list audit handler completed successfully", "count", len(events))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{Limit: defaultAuditLimit}
+
+	ids := map[string]**int64{
+		"actor_id":  &filter.ActorID,
+		"target_id": &filter.TargetID,
+		"user_id":   &filter.UserID,
+	}
+	for name, field := range ids {
+		if v := query.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %s", name, v)
+			}
+			*field = &id
+		}
+	}
+
+	if types := query.Get("type"); types != "" {
+		for t := range strings.SplitSeq(types, ",") {
+			filter.Types = append(filter.Types, strings.TrimSpace(t))
+		}
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since time format (use RFC3339): %s", since)
+		}
+		filter.Since = &t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until time format (use RFC3339): %s", until)
+		}
+		filter.Until = &t
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		before, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before <= 0 {
+			return filter, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+		filter.Before = before
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)
+		}
+		filter.Limit = n
+	}
+
+	return filter, nil
+}
@@ -1,13 +1,20 @@
 package route
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"citadel/internal/audit"
 	"citadel/internal/auth"
 	"citadel/internal/cache"
+	"citadel/internal/mail"
+	"citadel/internal/mfa"
 	"citadel/internal/middleware"
+	"citadel/internal/password"
 	"citadel/internal/user"
 
 	"github.com/google/uuid"
@@ -18,7 +25,13 @@ import (
 func Register(
 	db *sqlx.DB,
 	rdb *redis.Client,
+	hasher *password.Hasher,
+	policy *password.Policy,
 	issuer *auth.Issuer,
+	mailer mail.Mailer,
+	appURL string,
+	requireVerifiedEmail bool,
+	cookies CookieConfig,
 ) http.HandlerFunc {
 	type Request struct {
 		Username string `json:"username"`
@@ -48,8 +61,28 @@ func Register(
 			return
 		}
 
+		var policyErr *password.PolicyError
+		err := policy.Check(req.Password, req.Username, req.Email)
+		if errors.As(err, &policyErr) {
+			log.Warn("registration validation failed: password policy", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error":      "Password does not meet requirements",
+				"violations": policyErr.Violations,
+			})
+			return
+		}
+		if err != nil {
+			log.Error("failed to check password policy", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to check password"})
+			return
+		}
+
 		log.Info("creating user in database", "email", req.Email)
-		userId, err := user.Create(ctx, db, user.CreateRequest{
+		userId, err := user.Create(ctx, db, hasher, user.CreateRequest{
 			Username: req.Username,
 			Email:    req.Email,
 			Password: req.Password,
@@ -69,46 +102,61 @@ func Register(
 			return
 		}
 		log.Info("user created in database", "user_id", userId)
+		recordAudit(r, db, audit.EventRegister, userId, userId, nil)
 
-		log.Info("generating access token", "user_id", userId)
-		accessToken, err := issuer.GenerateAccessToken(userId, req.Email, req.Username)
-		if err != nil {
-			log.Error("failed to generate access token", "error", err)
+		newUser := &user.User{UserId: userId, Username: req.Username, Email: req.Email}
+		log.Info("sending verification email", "user_id", userId)
+		if err := sendVerificationEmail(ctx, mailer, issuer, appURL, newUser); err != nil {
+			log.Error("failed to send verification email", "error", err)
+		}
+
+		if requireVerifiedEmail {
+			log.Info("register handler completed, awaiting email verification", "user_id", userId)
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{
+				"user_id":               userId,
+				"verification_required": true,
+			})
 			return
 		}
 
-		refreshToken := uuid.New().String()
-		ttl := 24 * time.Hour
-		log.Info("storing refresh token in redis", "user_id", userId)
-		if err := cache.StoreRefresh(ctx, rdb, refreshToken, userId, ttl); err != nil {
-			log.Error("failed to store refresh token", "error", err)
+		log.Info("starting session", "user_id", userId)
+		accessToken, refreshToken, err := startSession(
+			ctx,
+			db,
+			rdb,
+			issuer,
+			newUser,
+			"",
+			deviceFrom(r),
+		)
+		if err != nil {
+			log.Error("failed to start session", "error", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to store refresh token"})
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
 			return
 		}
 
 		log.Info("register handler completed successfully", "user_id", userId)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{
-			"access_token":  accessToken,
-			"refresh_token": refreshToken,
-		})
+		writeSession(w, r, cookies, http.StatusCreated, accessToken, refreshToken)
 	}
 }
 
 func Login(
 	db *sqlx.DB,
 	rdb *redis.Client,
+	hasher *password.Hasher,
 	issuer *auth.Issuer,
+	requireVerifiedEmail bool,
+	cookies CookieConfig,
 ) http.HandlerFunc {
 	type Request struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Scope optionally narrows the session to fewer scopes than the user holds
+		Scope string `json:"scope"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := middleware.GetLogger(r)
@@ -131,10 +179,20 @@ func Login(
 			return
 		}
 
+		throttleKeys := loginThrottleKeys(r, req.Email)
+		if !checkLoginThrottle(w, r, rdb, throttleKeys) {
+			return
+		}
+
 		log.Info("looking up user by email", "email", req.Email)
 		u, err := user.ByEmail(r.Context(), db, req.Email)
 		if err != nil {
 			log.Warn("login attempt for non-existent user", "email", req.Email)
+			recordAudit(r, db, audit.EventLoginFailure, 0, 0, map[string]any{
+				"email":  req.Email,
+				"reason": "unknown_user",
+			})
+			recordLoginFailure(r.Context(), log, rdb, throttleKeys)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid email or password"})
@@ -142,7 +200,7 @@ func Login(
 		}
 
 		log.Info("verifying password", "user_id", u.UserId)
-		match, err := user.Verify(req.Password, u.Hash, u.Salt)
+		match, needsRehash, err := hasher.Verify(req.Password, u.Hash, u.Salt)
 		if err != nil {
 			log.Error("failed to verify password", "error", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -152,47 +210,141 @@ func Login(
 		}
 		if !match {
 			log.Warn("failed login attempt: invalid password", "email", req.Email)
+			recordAudit(r, db, audit.EventLoginFailure, 0, u.UserId, map[string]any{
+				"reason": "invalid_password",
+			})
+			recordLoginFailure(r.Context(), log, rdb, throttleKeys)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid email or password"})
 			return
 		}
 
-		log.Info("generating access token", "user_id", u.UserId)
-		accessToken, err := issuer.GenerateAccessToken(u.UserId, u.Email, u.Username)
+		// Upgrade hashes made with an older algorithm, parameters or pepper
+		// while the plaintext is at hand. Failing to do so is not fatal.
+		if needsRehash {
+			rehashPassword(r.Context(), log, db, hasher, u, req.Password)
+		}
+
+		if u.SuspendedAt != nil {
+			log.Warn("login blocked: account suspended", "user_id", u.UserId)
+			recordAudit(r, db, audit.EventLoginFailure, 0, u.UserId, map[string]any{
+				"reason": "suspended",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Account suspended"})
+			return
+		}
+
+		if requireVerifiedEmail && u.EmailVerifiedAt == nil {
+			log.Warn("login blocked: email not verified", "user_id", u.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Email address not verified"})
+			return
+		}
+
+		mfaEnabled, err := mfa.IsEnabled(r.Context(), db, u.UserId)
 		if err != nil {
-			log.Error("failed to generate access token", "error", err)
+			log.Error("failed to check mfa enrollment", "error", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+			json.NewEncoder(w).Encode(map[string]string{"error": "Authentication error"})
+			return
+		}
+		if mfaEnabled {
+			log.Info("password verified, issuing mfa challenge", "user_id", u.UserId)
+			mfaToken, _, err := issuer.GenerateMFAToken(
+				u.UserId,
+				"password",
+				req.Scope,
+				mfaChallengeTTL,
+			)
+			if err != nil {
+				log.Error("failed to generate mfa token", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]any{
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+			})
 			return
 		}
 
-		refreshToken := uuid.New().String()
-		ttl := 24 * time.Hour
-		log.Info("storing refresh token in redis", "user_id", u.UserId)
-		if err := cache.StoreRefresh(r.Context(), rdb, refreshToken, u.UserId, ttl); err != nil {
-			log.Error("failed to store refresh token", "error", err)
+		log.Info("starting session", "user_id", u.UserId)
+		accessToken, refreshToken, err := startSession(
+			r.Context(),
+			db,
+			rdb,
+			issuer,
+			u,
+			req.Scope,
+			deviceFrom(r),
+		)
+		if errors.Is(err, auth.ErrInvalidScope) {
+			log.Warn("login validation failed: invalid scope", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Unknown or unavailable scope"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to start session", "error", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to store refresh token"})
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
 			return
 		}
 
-		log.Info("login handler completed successfully", "user_id", u.UserId)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"access_token":  accessToken,
-			"refresh_token": refreshToken,
+		// Only a completed login clears the account's failures; with MFA
+		// enabled, that is once the second factor is checked too
+		_, err = cache.ResetThrottle(r.Context(), rdb, emailThrottleKey(req.Email))
+		if err != nil {
+			log.Error("failed to reset login throttle", "error", err)
+		}
+
+		recordAudit(r, db, audit.EventLoginSuccess, u.UserId, u.UserId, map[string]any{
+			"method": "password",
 		})
+		log.Info("login handler completed successfully", "user_id", u.UserId)
+		writeSession(w, r, cookies, http.StatusOK, accessToken, refreshToken)
+	}
+}
+
+// rehashPassword replaces a verified user's stored hash with one made by the
+// current hasher configuration.
+func rehashPassword(
+	ctx context.Context,
+	log *slog.Logger,
+	db *sqlx.DB,
+	hasher *password.Hasher,
+	u *user.User,
+	plaintext string,
+) {
+	h, err := hasher.Hash(plaintext)
+	if err != nil {
+		log.Error("failed to rehash password", "error", err, "user_id", u.UserId)
+		return
+	}
+	if err := user.SetPasswordHash(ctx, db, u.UserId, u.Hash, h); err != nil {
+		log.Error("failed to store rehashed password", "error", err, "user_id", u.UserId)
+		return
 	}
+	log.Info("upgraded password hash", "user_id", u.UserId)
 }
 
 func RefreshToken(
 	db *sqlx.DB,
 	rdb *redis.Client,
 	issuer *auth.Issuer,
+	cookies CookieConfig,
 ) http.HandlerFunc {
 	type Request struct {
 		RefreshToken string `json:"refresh_token"`
@@ -202,7 +354,12 @@ func RefreshToken(
 		log.Info("refresh token handler started")
 
 		var req Request
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if cookies.requested(r) {
+			// Cookie mode sends the refresh token as a cookie, with no body
+			if cookie, err := r.Cookie(middleware.RefreshCookie); err == nil {
+				req.RefreshToken = cookie.Value
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			log.Error("failed to decode refresh request", "error", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
@@ -218,8 +375,41 @@ func RefreshToken(
 			return
 		}
 
-		log.Info("looking up refresh token in redis")
-		userID, err := cache.GetRefresh(r.Context(), rdb, req.RefreshToken)
+		newRefreshToken := uuid.New().String()
+
+		log.Info("rotating refresh token in redis")
+		refresh, err := cache.RotateRefresh(
+			r.Context(),
+			rdb,
+			req.RefreshToken,
+			newRefreshToken,
+			deviceFrom(r),
+			refreshTTL,
+		)
+		if errors.Is(err, cache.ErrRefreshReused) {
+			// A retired token means the family has leaked: kill every token in it
+			userID, revokeErr := cache.RevokeFamily(r.Context(), rdb, refresh.FamilyID)
+			log.Warn("security event: refresh token reuse detected, revoking token family",
+				"security_event", "refresh_token_reuse",
+				"family_id", refresh.FamilyID,
+				"user_id", userID,
+			)
+			if revokeErr != nil {
+				log.Error("failed to revoke token family",
+					"error", revokeErr,
+					"family_id", refresh.FamilyID,
+				)
+			}
+			recordAudit(r, db, audit.EventTokenRevoke, 0, userID, map[string]any{
+				"reason":     "refresh_token_reuse",
+				"session_id": refresh.FamilyID,
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Invalid or expired refresh token"})
+			return
+		}
 		if err != nil {
 			log.Warn("invalid refresh token attempt", "error", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -229,52 +419,69 @@ func RefreshToken(
 			return
 		}
 
-		log.Info("fetching user from database", "user_id", userID)
-		u, err := user.ByID(r.Context(), db, userID)
+		log.Info("fetching user from database", "user_id", refresh.UserID)
+		u, err := user.ByID(r.Context(), db, refresh.UserID)
 		if err != nil {
 			log.Error("failed to fetch user", "error", err)
+			if _, err := cache.RevokeFamily(r.Context(), rdb, refresh.FamilyID); err != nil {
+				log.Error("failed to revoke token family",
+					"error", err,
+					"family_id", refresh.FamilyID,
+				)
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
 			return
 		}
 
-		log.Info("generating new access token", "user_id", u.UserId)
-		accessToken, err := issuer.GenerateAccessToken(u.UserId, u.Email, u.Username)
+		if u.SuspendedAt != nil {
+			log.Warn("refresh blocked: account suspended", "user_id", u.UserId)
+			if _, err := cache.RevokeFamily(r.Context(), rdb, refresh.FamilyID); err != nil {
+				log.Error("failed to revoke token family",
+					"error", err,
+					"family_id", refresh.FamilyID,
+				)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Account suspended"})
+			return
+		}
+
+		subject, err := loadSubject(r.Context(), db, u)
 		if err != nil {
-			log.Error("failed to generate access token", "error", err)
+			log.Error("failed to load user roles", "error", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
 			return
 		}
-
-		newRefreshToken := uuid.New().String()
-		ttl := 24 * time.Hour
-
-		log.Info("rotating refresh token in redis", "user_id", u.UserId)
-		if err := cache.DeleteRefresh(r.Context(), rdb, req.RefreshToken, u.UserId); err != nil {
-			log.Error("failed to delete old refresh token", "error", err)
+		subject.SessionID = refresh.FamilyID
+		// A narrowed session keeps its scope, less anything the user has since lost
+		if refresh.Scope != "" {
+			subject.Scope = auth.RetainScope(refresh.Scope, subject.Scope)
 		}
-		if err := cache.StoreRefresh(r.Context(), rdb, newRefreshToken, u.UserId, ttl); err != nil {
-			log.Error("failed to store new refresh token", "error", err)
+
+		log.Info("generating new access token", "user_id", u.UserId)
+		accessToken, err := issueAccessToken(r.Context(), rdb, issuer, subject)
+		if err != nil {
+			log.Error("failed to generate access token", "error", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to rotate refresh token"})
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
 			return
 		}
 
-		log.Info("refresh token handler completed successfully", "user_id", u.UserId)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"access_token":  accessToken,
-			"refresh_token": newRefreshToken,
+		recordAudit(r, db, audit.EventRefresh, u.UserId, u.UserId, map[string]any{
+			"session_id": refresh.FamilyID,
 		})
+		log.Info("refresh token handler completed successfully", "user_id", u.UserId)
+		writeSession(w, r, cookies, http.StatusOK, accessToken, newRefreshToken)
 	}
 }
 
-func Logout(rdb *redis.Client) http.HandlerFunc {
+func Logout(db *sqlx.DB, rdb *redis.Client, cookies CookieConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := middleware.GetLogger(r)
 		log.Info("logout handler started")
@@ -289,19 +496,43 @@ func Logout(rdb *redis.Client) http.HandlerFunc {
 			return
 		}
 
-		log.Info("blacklisting access token", "user_id", claims.UserId, "jti", claims.ID)
-		ttl := time.Until(claims.ExpiresAt.Time)
-		if ttl > 0 {
-			if err := cache.Blacklist(ctx, rdb, claims.ID, ttl); err != nil {
-				log.Error("failed to blacklist token", "error", err, "jti", claims.ID)
+		// API tokens are revoked through /me/tokens, not by logging out
+		if claims.APITokenID == 0 {
+			log.Info("blacklisting access token", "user_id", claims.UserId, "jti", claims.ID)
+			ttl := time.Until(claims.ExpiresAt.Time)
+			if ttl > 0 {
+				if err := cache.Blacklist(ctx, rdb, claims.ID, ttl); err != nil {
+					log.Error("failed to blacklist token", "error", err, "jti", claims.ID)
+				}
 			}
 		}
 
+		// Ending an impersonation must leave the user's own sessions alone
+		if claims.Impersonated() {
+			recordAudit(
+				r,
+				db,
+				audit.EventImpersonateEnd,
+				claims.Actor.UserId,
+				claims.UserId,
+				map[string]any{"jti": claims.ID},
+			)
+			log.Info("logout handler completed successfully: impersonation ended",
+				"user_id", claims.UserId,
+			)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
 		log.Info("deleting user refresh tokens from redis", "user_id", claims.UserId)
 		if err := cache.DeleteUserRefresh(ctx, rdb, claims.UserId); err != nil {
 			log.Error("failed to delete refresh tokens", "error", err)
 		}
 
+		clearSessionCookies(w, r, cookies)
+
+		recordAudit(r, db, audit.EventLogout, claims.UserId, claims.UserId, nil)
+
 		log.Info(
 			"logout handler completed successfully",
 			"user_id",
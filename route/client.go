@@ -0,0 +1,140 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"citadel/internal/middleware"
+	"citadel/internal/oauth"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// clientResponse is how a client is shown to admins.
+type clientResponse struct {
+	oauth.Client
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"`
+}
+
+func newClientResponse(c *oauth.Client) clientResponse {
+	return clientResponse{
+		Client:       *c,
+		RedirectURIs: c.Redirects(),
+		Confidential: c.Confidential(),
+	}
+}
+
+func ListClients(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("list clients handler started")
+
+		clients, err := oauth.ListClients(r.Context(), db)
+		if err != nil {
+			log.Error("failed to list clients", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list clients"})
+			return
+		}
+
+		response := make([]clientResponse, len(clients))
+		for i := range clients {
+			response[i] = newClientResponse(&clients[i])
+		}
+
+		log.Info("list clients handler completed successfully", "count", len(response))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func CreateClient(db *sqlx.DB) http.HandlerFunc {
+	type Request struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("create client handler started")
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("create client failed: invalid request body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		if req.Name == "" || len(req.RedirectURIs) == 0 {
+			log.Warn("create client validation failed: missing required fields")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Name and redirect_uris are required"})
+			return
+		}
+
+		client, secret, err := oauth.CreateClient(
+			r.Context(),
+			db,
+			req.Name,
+			req.RedirectURIs,
+			req.Confidential,
+		)
+		if errors.Is(err, oauth.ErrInvalidRedirect) {
+			log.Warn("create client validation failed: invalid redirect uri", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid redirect URI"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to create client", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create client"})
+			return
+		}
+
+		log.Info("create client handler completed successfully", "client_id", client.ClientID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			clientResponse
+			ClientSecret string `json:"client_secret,omitempty"`
+		}{newClientResponse(client), secret})
+	}
+}
+
+func DeleteClient(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("delete client handler started")
+
+		clientID := r.PathValue("id")
+		err := oauth.DeleteClient(r.Context(), db, clientID)
+		if errors.Is(err, oauth.ErrClientNotFound) {
+			log.Warn("delete client failed: client not found", "client_id", clientID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Client not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete client", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete client"})
+			return
+		}
+
+		log.Info("delete client handler completed successfully", "client_id", clientID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,87 @@
+package route
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"citadel/internal/middleware"
+)
+
+// CookieConfig configures cookie session mode, in which browser clients keep
+// their tokens in HttpOnly cookies rather than in JavaScript-readable storage.
+type CookieConfig struct {
+	// Enabled lets clients opt in to cookie mode per request
+	Enabled bool
+	// Domain is set on every cookie so a web app on a sibling subdomain can
+	// read the CSRF token. Leave empty for host-only cookies.
+	Domain string
+}
+
+// requested reports whether the client asked for cookie mode.
+func (c CookieConfig) requested(r *http.Request) bool {
+	return c.Enabled && strings.EqualFold(r.Header.Get(middleware.SessionModeHeader), "cookie")
+}
+
+func (c CookieConfig) cookie(name, value, path string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: httpOnly,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// writeSession sends a new token pair to the client. API clients get both
+// tokens in the JSON body. In cookie mode they are set as HttpOnly cookies,
+// with the refresh token only sent back to /refresh, and the body carries the
+// CSRF token the client must echo on state-changing requests.
+func writeSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	cookies CookieConfig,
+	status int,
+	accessToken, refreshToken string,
+) {
+	if !cookies.requested(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token":  accessToken,
+			"refresh_token": refreshToken,
+		})
+		return
+	}
+
+	csrfToken := rand.Text()
+	refreshMaxAge := int(refreshTTL.Seconds())
+	http.SetCookie(w, cookies.cookie(middleware.AccessCookie, accessToken, "/", 0, true))
+	http.SetCookie(
+		w,
+		cookies.cookie(middleware.RefreshCookie, refreshToken, "/refresh", refreshMaxAge, true),
+	)
+	// Readable by the web app so it can copy it into the CSRF header
+	http.SetCookie(w, cookies.cookie(middleware.CSRFCookie, csrfToken, "/", refreshMaxAge, false))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"csrf_token": csrfToken})
+}
+
+// clearSessionCookies expires the cookie mode session cookies, if any were sent.
+func clearSessionCookies(w http.ResponseWriter, r *http.Request, cookies CookieConfig) {
+	if !cookies.Enabled {
+		return
+	}
+	if _, err := r.Cookie(middleware.AccessCookie); err != nil {
+		return
+	}
+	http.SetCookie(w, cookies.cookie(middleware.AccessCookie, "", "/", -1, true))
+	http.SetCookie(w, cookies.cookie(middleware.RefreshCookie, "", "/refresh", -1, true))
+	http.SetCookie(w, cookies.cookie(middleware.CSRFCookie, "", "/", -1, false))
+}
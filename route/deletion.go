@@ -0,0 +1,137 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// DeleteUser deletes an account, either the caller's own or, for admins, any
+// account. The account is signed out everywhere and stops resolving at once,
+// but is only purged after the grace period, until which an admin can
+// restore it.
+func DeleteUser(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	gracePeriod time.Duration,
+	cookies CookieConfig,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("delete user handler started")
+
+		ctx := r.Context()
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("delete user validation failed: invalid user ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+
+		purgeAfter := time.Now().Add(gracePeriod).UTC().Truncate(time.Second)
+		epoch, err := user.Delete(ctx, db, userID, purgeAfter)
+		if errors.Is(err, user.ErrNotFound) {
+			log.Warn("delete user failed: user not found", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete user", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete user"})
+			return
+		}
+		log.Warn("security event: account deleted",
+			"security_event", "account_deleted",
+			"user_id", userID,
+			"deleted_by", actorID(r),
+			"purge_after", purgeAfter,
+		)
+		recordAudit(r, db, audit.EventUserDelete, actorID(r), userID, map[string]any{
+			"purge_after": purgeAfter,
+		})
+
+		if err := publishTokenEpoch(ctx, rdb, userID, epoch); err != nil {
+			log.Error("failed to revoke user tokens", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "User deleted but failed to revoke sessions"})
+			return
+		}
+
+		if userID == actorID(r) {
+			clearSessionCookies(w, r, cookies)
+		}
+
+		log.Info("delete user handler completed successfully", "user_id", userID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{
+			"user_id":     userID,
+			"purge_after": purgeAfter,
+		})
+	}
+}
+
+// RestoreUser undoes a deletion during its grace period.
+func RestoreUser(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("restore user handler started")
+
+		ctx := r.Context()
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("restore user validation failed: invalid user ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+
+		err = user.Restore(ctx, db, userID)
+		if errors.Is(err, user.ErrNotDeleted) {
+			log.Warn("restore user failed: no deleted user", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "No deleted user with that ID"})
+			return
+		}
+		if user.IsConflict(err) {
+			log.Warn("restore user failed: username or email taken", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "The username or email has been taken since the user was deleted",
+			})
+			return
+		}
+		if err != nil {
+			log.Error("failed to restore user", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to restore user"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventUserRestore, actorID(r), userID, nil)
+
+		log.Info("restore user handler completed successfully", "user_id", userID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,246 @@
+package route
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/cache"
+	"citadel/internal/export"
+	"citadel/internal/middleware"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// exportTimeout bounds how long building an archive may take.
+const exportTimeout = 10 * time.Minute
+
+// exportResponse is how an export is shown to its owner. DownloadURL is only
+// set once the archive is ready, and stops working when the export expires.
+type exportResponse struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DownloadURL string    `json:"download_url,omitempty"`
+}
+
+func newExportResponse(e *cache.Export) exportResponse {
+	response := exportResponse{
+		ID:        e.ID,
+		Status:    e.Status,
+		CreatedAt: e.CreatedAt,
+		ExpiresAt: e.ExpiresAt,
+	}
+	if e.Status == cache.ExportReady {
+		response.DownloadURL = fmt.Sprintf("/me/exports/%s/download", e.ID)
+	}
+	return response
+}
+
+// StartExport builds an archive of the caller's personal data in the
+// background. The archive is kept for ttl, after which it must be requested
+// again.
+func StartExport(db *sqlx.DB, rdb *redis.Client, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("start export handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("start export failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		e, err := cache.StartExport(
+			r.Context(),
+			rdb,
+			rand.Text(),
+			claims.UserId,
+			ttl,
+			exportTimeout,
+		)
+		if errors.Is(err, cache.ErrExportInProgress) {
+			log.Warn("start export failed: export in progress", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "An export is already being prepared"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to start export", "error", err, "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start export"})
+			return
+		}
+		log.Warn("security event: data export requested",
+			"security_event", "data_export_requested",
+			"user_id", claims.UserId,
+			"export_id", e.ID,
+		)
+		recordAudit(r, db, audit.EventDataExport, claims.UserId, claims.UserId, nil)
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), exportTimeout)
+		go func() {
+			defer cancel()
+
+			var archive bytes.Buffer
+			manifest, err := export.Write(ctx, db, rdb, e.UserID, &archive)
+			if err != nil {
+				log.Error("failed to build export", "error", err, "export_id", e.ID)
+				if err := cache.FailExport(ctx, rdb, e.ID, e.UserID); err != nil {
+					log.Error("failed to mark export failed", "error", err, "export_id", e.ID)
+				}
+				return
+			}
+			if err := cache.FinishExport(ctx, rdb, e.ID, e.UserID, archive.Bytes()); err != nil {
+				log.Error("failed to store export", "error", err, "export_id", e.ID)
+				return
+			}
+			log.Info("export ready",
+				"export_id", e.ID,
+				"files", len(manifest.Files),
+				"size", archive.Len(),
+			)
+		}()
+
+		log.Info("start export handler completed successfully", "export_id", e.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", fmt.Sprintf("/me/exports/%s", e.ID))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(newExportResponse(e))
+	}
+}
+
+// GetExport reports whether the caller's export is ready.
+func GetExport(rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("get export handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("get export failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		// Someone else's export is reported as missing, so IDs cannot be probed
+		e, err := cache.GetExport(r.Context(), rdb, r.PathValue("id"))
+		if err == nil && e.UserID != claims.UserId {
+			err = cache.ErrExportNotFound
+		}
+		if errors.Is(err, cache.ErrExportNotFound) {
+			log.Warn("get export failed: export not found", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Export not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to get export", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get export"})
+			return
+		}
+
+		log.Info("get export handler completed successfully", "export_id", e.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(newExportResponse(e))
+	}
+}
+
+// DownloadExport serves the archive of the caller's ready export.
+func DownloadExport(rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("download export handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("download export failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		// Someone else's export is reported as missing, so IDs cannot be probed
+		e, err := cache.GetExport(r.Context(), rdb, r.PathValue("id"))
+		if err == nil && e.UserID != claims.UserId {
+			err = cache.ErrExportNotFound
+		}
+		if errors.Is(err, cache.ErrExportNotFound) {
+			log.Warn("download export failed: export not found", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Export not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to get export", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get export"})
+			return
+		}
+		if e.Status != cache.ExportReady {
+			log.Warn("download export failed: export not ready",
+				"export_id", e.ID,
+				"status", e.Status,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Export is not ready"})
+			return
+		}
+
+		archive, err := cache.ExportArchive(r.Context(), rdb, e.ID)
+		if errors.Is(err, cache.ErrExportNotFound) {
+			log.Warn("download export failed: export expired", "export_id", e.ID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Export not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to get export archive", "error", err, "export_id", e.ID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get export"})
+			return
+		}
+		log.Warn("security event: data export downloaded",
+			"security_event", "data_export_downloaded",
+			"user_id", e.UserID,
+			"export_id", e.ID,
+		)
+
+		log.Info("download export handler completed successfully", "export_id", e.ID)
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			`attachment; filename="citadel-export-%d-%s.zip"`,
+			e.UserID,
+			e.CreatedAt.Format("20060102"),
+		))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(archive)
+	}
+}
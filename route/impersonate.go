@@ -0,0 +1,234 @@
+package route
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultImpersonationTTL = 15 * time.Minute
+	maxImpersonationTTL     = time.Hour
+)
+
+// Impersonate mints a short-lived access token that lets an admin act as
+// another user. The token names the admin in its act claim, cannot be
+// refreshed and is refused by routes that manage credentials.
+func Impersonate(db *sqlx.DB, rdb *redis.Client, issuer *auth.Issuer) http.HandlerFunc {
+	type Request struct {
+		Reason     string `json:"reason"`
+		TTLSeconds int    `json:"ttl_seconds"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("impersonate handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("impersonate failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		if claims.APITokenID != 0 {
+			log.Warn("impersonate failed: authenticated with an api token")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "API tokens cannot impersonate users"})
+			return
+		}
+
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("impersonate validation failed: invalid user ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+
+		if userID == claims.UserId {
+			log.Warn("impersonate validation failed: cannot impersonate self", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "You cannot impersonate yourself"})
+			return
+		}
+
+		// The body is optional
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			log.Warn("impersonate failed: invalid request body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		ttl := defaultImpersonationTTL
+		if req.TTLSeconds != 0 {
+			ttl = time.Duration(req.TTLSeconds) * time.Second
+		}
+		if ttl <= 0 || ttl > maxImpersonationTTL {
+			log.Warn("impersonate validation failed: invalid ttl", "ttl_seconds", req.TTLSeconds)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "ttl_seconds must be between 1 and " +
+					strconv.Itoa(int(maxImpersonationTTL.Seconds())),
+			})
+			return
+		}
+
+		target, err := user.ByID(ctx, db, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("impersonate failed: user not found", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to fetch user", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to impersonate user"})
+			return
+		}
+
+		if target.SuspendedAt != nil {
+			log.Warn("impersonate failed: account suspended", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Cannot impersonate a suspended account"})
+			return
+		}
+
+		subject, err := loadSubject(ctx, db, target)
+		if err != nil {
+			log.Error("failed to load user roles", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to impersonate user"})
+			return
+		}
+
+		// Impersonation must not be a way to gain permissions
+		for _, permission := range subject.Permissions {
+			if !claims.HasPermission(permission) {
+				log.Warn(
+					"impersonate failed: target holds permission actor lacks",
+					"user_id", userID,
+					"permission", permission,
+				)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Cannot impersonate a user with permissions you do not hold",
+				})
+				return
+			}
+		}
+		// Nor a way to widen a narrowed session
+		subject.Scope = auth.RetainScope(subject.Scope, claims.Scope)
+
+		actor := auth.NewActor(claims.UserId, claims.Username)
+		token, tokenClaims, err := issuer.GenerateImpersonationToken(subject, actor, ttl)
+		if err != nil {
+			log.Error("failed to generate impersonation token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to impersonate user"})
+			return
+		}
+
+		expiresAt := tokenClaims.ExpiresAt.Time
+		err = cache.TrackImpersonation(ctx, rdb, claims.UserId, tokenClaims.ID, expiresAt)
+		if err != nil {
+			log.Error("failed to track impersonation token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to impersonate user"})
+			return
+		}
+
+		log.Warn("security event: impersonation started",
+			"security_event", "impersonation_started",
+			"user_id", userID,
+			"impersonator_id", claims.UserId,
+			"expires_at", expiresAt,
+		)
+		recordAudit(r, db, audit.EventImpersonate, claims.UserId, userID, map[string]any{
+			"reason":     req.Reason,
+			"jti":        tokenClaims.ID,
+			"expires_at": expiresAt.UTC(),
+		})
+
+		log.Info("impersonate handler completed successfully", "user_id", userID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": token,
+			"expires_in":   int(ttl.Seconds()),
+		})
+	}
+}
+
+// EndImpersonations revokes every impersonation token the caller has minted.
+func EndImpersonations(db *sqlx.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("end impersonations handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("end impersonations failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		count, err := cache.RevokeImpersonations(r.Context(), rdb, claims.UserId)
+		if err != nil {
+			log.Error("failed to revoke impersonation tokens", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Failed to end impersonation"})
+			return
+		}
+
+		if count > 0 {
+			recordAudit(r, db, audit.EventImpersonateEnd, claims.UserId, 0, map[string]any{
+				"count": count,
+			})
+		}
+
+		log.Info(
+			"end impersonations handler completed successfully",
+			"user_id", claims.UserId,
+			"count", count,
+		)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
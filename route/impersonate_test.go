@@ -0,0 +1,58 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestImpersonateScope(t *testing.T) {
+	tests := []struct {
+		name string
+		// scope narrows the impersonating admin's session
+		scope string
+		// wantScope is the impersonation token's scope
+		wantScope   string
+		usersStatus int
+	}{
+		{
+			name: "full session",
+			wantScope: "account audit:read clients:manage logs:stream roles:manage " +
+				"users:impersonate users:read users:write",
+			usersStatus: http.StatusOK,
+		},
+		{
+			name:        "narrowed session",
+			scope:       "users:impersonate",
+			wantScope:   "users:impersonate",
+			usersStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			registerUser(t, s, "alice", "alice@example.com")
+			grantRole(t, s, "alice", "admin")
+			target := registerUser(t, s, "bob", "bob@example.com")
+			grantRole(t, s, "bob", "admin")
+
+			session := login(t, s, "alice@example.com", tt.scope)
+			var impersonation struct {
+				AccessToken string `json:"access_token"`
+			}
+			path := userPath(userID(t, s, target.AccessToken)) + "/impersonate"
+			s.mustDo(t, http.StatusCreated, "POST", path, session.AccessToken, nil, &impersonation)
+
+			var claims struct {
+				Scope string `json:"scope"`
+			}
+			s.mustDo(t, http.StatusOK, "GET", "/me", impersonation.AccessToken, nil, &claims)
+			if claims.Scope != tt.wantScope {
+				t.Errorf("got scope %q, want %q", claims.Scope, tt.wantScope)
+			}
+			got := s.do(t, "GET", "/users", impersonation.AccessToken, nil, nil)
+			if got != tt.usersStatus {
+				t.Errorf("got status %d listing users, want %d", got, tt.usersStatus)
+			}
+		})
+	}
+}
@@ -3,11 +3,17 @@ package route
 import (
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"time"
 
 	"citadel/internal/auth"
+	"citadel/internal/cache"
 	"citadel/internal/logging"
+	"citadel/internal/mail"
 	"citadel/internal/middleware"
+	"citadel/internal/password"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
 )
@@ -15,21 +21,52 @@ import (
 type Config struct {
 	Db          *sqlx.DB
 	Redis       *redis.Client
+	Revocations *cache.Revocations
 	Issuer      *auth.Issuer
 	Logger      *slog.Logger
 	LogManager  *logging.Manager
 	Broadcaster *logging.Broadcaster
+	Mailer      mail.Mailer
+	Hasher      *password.Hasher
+	// PasswordPolicy is enforced whenever a password is set
+	PasswordPolicy *password.Policy
+	// AppURL is the base URL of the web app that serves links sent by email
+	AppURL string
+	// RequireVerifiedEmail blocks login until the email address is verified
+	RequireVerifiedEmail bool
+	// IssuerURL is the public base URL of citadel, used as the OpenID Connect issuer
+	IssuerURL string
+	WebAuthn  *webauthn.WebAuthn
+	// Cookies configures the optional cookie session mode for browser clients
+	Cookies CookieConfig
+	// TrustedProxies are the reverse proxies whose forwarding headers name the
+	// client IP. With none, the client is whoever connected
+	TrustedProxies []netip.Prefix
+	// DeletionGracePeriod is how long a deleted account can be restored before
+	// it is purged
+	DeletionGracePeriod time.Duration
+	// ExportTTL is how long a personal data export can be downloaded
+	ExportTTL time.Duration
 }
 
 func Initialize(config Config) http.Handler {
 	// Base chain for all routes
+	// Cookie mode needs credentialed CORS requests from the web app
+	var credentialOrigins []string
+	if config.Cookies.Enabled {
+		credentialOrigins = append(credentialOrigins, config.AppURL)
+	}
 	baseChain := middleware.New(
-		middleware.CORS,
+		middleware.RealIP(config.TrustedProxies),
+		middleware.CORS(credentialOrigins...),
 		middleware.RequestLogger(config.Logger),
+		middleware.CSRF,
 	)
 
 	// Protected chain extends base with auth
-	protectedChain := baseChain.Use(middleware.RequireAuth(config.Issuer, config.Redis))
+	protectedChain := baseChain.Use(
+		middleware.RequireAuth(config.Issuer, config.Revocations, config.Redis, config.Db),
+	)
 
 	mux := http.NewServeMux()
 
@@ -40,24 +77,276 @@ func Initialize(config Config) http.Handler {
 
 	// Public routes - use base chain
 	mux.Handle("GET /health", baseChain.ThenFunc(GetHealth()))
+	mux.Handle("GET /.well-known/jwks.json", baseChain.ThenFunc(GetJWKS(config.Issuer)))
+	mux.Handle(
+		"GET /.well-known/openid-configuration",
+		baseChain.ThenFunc(GetOpenIDConfiguration(config.Issuer, config.IssuerURL)),
+	)
+	mux.Handle("GET /authorize", baseChain.ThenFunc(Authorize(config.Db, config.AppURL)))
+	mux.Handle(
+		"POST /token",
+		baseChain.ThenFunc(Token(config.Db, config.Redis, config.Issuer, config.IssuerURL)),
+	)
+	mux.Handle(
+		"POST /introspect",
+		baseChain.ThenFunc(Introspect(config.Db, config.Redis, config.Issuer)),
+	)
+	mux.Handle("POST /revoke", baseChain.ThenFunc(Revoke(config.Db, config.Redis, config.Issuer)))
+	userInfo := baseChain.ThenFunc(UserInfo(config.Db, config.Redis, config.Issuer))
+	mux.Handle("GET /userinfo", userInfo)
+	mux.Handle("POST /userinfo", userInfo)
 	mux.Handle(
 		"POST /register",
-		baseChain.ThenFunc(Register(config.Db, config.Redis, config.Issuer)),
+		baseChain.ThenFunc(Register(
+			config.Db,
+			config.Redis,
+			config.Hasher,
+			config.PasswordPolicy,
+			config.Issuer,
+			config.Mailer,
+			config.AppURL,
+			config.RequireVerifiedEmail,
+			config.Cookies,
+		)),
+	)
+	mux.Handle(
+		"POST /login",
+		baseChain.ThenFunc(Login(
+			config.Db,
+			config.Redis,
+			config.Hasher,
+			config.Issuer,
+			config.RequireVerifiedEmail,
+			config.Cookies,
+		)),
+	)
+	mux.Handle(
+		"POST /login/mfa",
+		baseChain.ThenFunc(LoginMFA(config.Db, config.Redis, config.Issuer, config.Cookies)),
+	)
+	mux.Handle(
+		"POST /login/magic",
+		baseChain.ThenFunc(RequestMagicLink(
+			config.Db,
+			config.Redis,
+			config.Issuer,
+			config.Mailer,
+			config.AppURL,
+		)),
+	)
+	mux.Handle(
+		"POST /login/magic/verify",
+		baseChain.ThenFunc(VerifyMagicLink(config.Db, config.Redis, config.Issuer, config.Cookies)),
+	)
+	mux.Handle(
+		"POST /login/passkey/begin",
+		baseChain.ThenFunc(BeginPasskeyLogin(config.Redis, config.WebAuthn)),
+	)
+	mux.Handle(
+		"POST /login/passkey/finish",
+		baseChain.ThenFunc(FinishPasskeyLogin(
+			config.Db,
+			config.Redis,
+			config.Issuer,
+			config.WebAuthn,
+			config.RequireVerifiedEmail,
+			config.Cookies,
+		)),
+	)
+	mux.Handle("POST /verify-email", baseChain.ThenFunc(VerifyEmail(config.Db, config.Issuer)))
+	mux.Handle(
+		"POST /verify-email/resend",
+		baseChain.ThenFunc(
+			ResendVerification(
+				config.Db,
+				config.Redis,
+				config.Mailer,
+				config.Issuer,
+				config.AppURL,
+			),
+		),
+	)
+	mux.Handle(
+		"POST /password/forgot",
+		baseChain.ThenFunc(ForgotPassword(config.Db, config.Mailer, config.AppURL)),
+	)
+	mux.Handle(
+		"POST /password/reset",
+		baseChain.ThenFunc(ResetPassword(
+			config.Db,
+			config.Redis,
+			config.Hasher,
+			config.PasswordPolicy,
+		)),
 	)
-	mux.Handle("POST /login", baseChain.ThenFunc(Login(config.Db, config.Redis, config.Issuer)))
 	mux.Handle(
 		"POST /refresh",
-		baseChain.ThenFunc(RefreshToken(config.Db, config.Redis, config.Issuer)),
+		baseChain.ThenFunc(RefreshToken(config.Db, config.Redis, config.Issuer, config.Cookies)),
 	)
 
 	// Protected routes - use protected chain
 	mux.Handle("GET /me", protectedChain.ThenFunc(GetMe()))
-	mux.Handle("POST /logout", protectedChain.ThenFunc(Logout(config.Redis)))
-	mux.Handle("GET /users", protectedChain.ThenFunc(ListUsers(config.Db)))
-	mux.Handle("PATCH /users/{id}", protectedChain.ThenFunc(UpdateUser(config.Db)))
+	mux.Handle(
+		"POST /logout",
+		protectedChain.ThenFunc(Logout(config.Db, config.Redis, config.Cookies)),
+	)
+	// Self-service routes need the account scope
+	accountChain := protectedChain.Use(middleware.RequireScope(auth.ScopeAccount))
+	mux.Handle("GET /me/sessions", accountChain.ThenFunc(ListSessions(config.Redis)))
+	mux.Handle(
+		"DELETE /me/sessions/{id}",
+		accountChain.ThenFunc(DeleteSession(config.Db, config.Redis)),
+	)
+	mux.Handle("GET /me/tokens", accountChain.ThenFunc(ListAPITokens(config.Db)))
+	// Impersonation tokens cannot change how the user signs in or mint credentials
+	credentialsChain := accountChain.Use(middleware.DenyImpersonation)
+	mux.Handle("POST /me/tokens", credentialsChain.ThenFunc(CreateAPIToken(config.Db)))
+	mux.Handle("DELETE /me/tokens/{id}", credentialsChain.ThenFunc(DeleteAPIToken(config.Db)))
+	mux.Handle("GET /me/passkeys", accountChain.ThenFunc(ListPasskeys(config.Db)))
+	mux.Handle(
+		"POST /me/passkeys/register/begin",
+		credentialsChain.ThenFunc(
+			BeginPasskeyRegistration(config.Db, config.Redis, config.WebAuthn),
+		),
+	)
+	mux.Handle(
+		"POST /me/passkeys/register/finish",
+		credentialsChain.ThenFunc(
+			FinishPasskeyRegistration(config.Db, config.Redis, config.WebAuthn),
+		),
+	)
+	mux.Handle("DELETE /me/passkeys/{id}", credentialsChain.ThenFunc(DeletePasskey(config.Db)))
+	mux.Handle("POST /me/mfa/totp", credentialsChain.ThenFunc(EnrollTOTP(config.Db)))
+	mux.Handle("POST /me/mfa/totp/confirm", credentialsChain.ThenFunc(ConfirmTOTP(config.Db)))
+	mux.Handle("DELETE /me/mfa/totp", credentialsChain.ThenFunc(DisableTOTP(config.Db)))
+	mux.Handle(
+		"POST /me/mfa/recovery-codes",
+		credentialsChain.ThenFunc(RegenerateRecoveryCodes(config.Db)),
+	)
+	mux.Handle(
+		"POST /authorize",
+		credentialsChain.ThenFunc(ApproveAuthorization(config.Db, config.Redis)),
+	)
+	mux.Handle(
+		"POST /me/export",
+		credentialsChain.ThenFunc(StartExport(config.Db, config.Redis, config.ExportTTL)),
+	)
+	mux.Handle("GET /me/exports/{id}", credentialsChain.ThenFunc(GetExport(config.Redis)))
+	mux.Handle(
+		"GET /me/exports/{id}/download",
+		credentialsChain.ThenFunc(DownloadExport(config.Redis)),
+	)
+	mux.Handle(
+		"DELETE /me/impersonations",
+		credentialsChain.ThenFunc(EndImpersonations(config.Db, config.Redis)),
+	)
+	mux.Handle("POST /users/{id}/impersonate", protectedChain.Use(
+		middleware.DenyImpersonation,
+	).Use(
+		middleware.RequirePermission(auth.PermissionImpersonate),
+	).Use(
+		middleware.RequireScope(auth.PermissionImpersonate),
+	).ThenFunc(
+		Impersonate(config.Db, config.Redis, config.Issuer),
+	))
+	mux.Handle(
+		"PATCH /users/{id}",
+		protectedChain.Use(
+			middleware.RequireSelfOrPermission("id", auth.PermissionUsersWrite),
+		).ThenFunc(UpdateUser(
+			config.Db,
+			config.Hasher,
+			config.PasswordPolicy,
+			config.Redis,
+			config.Mailer,
+			config.Issuer,
+			config.AppURL,
+		)),
+	)
 
-	// SSE log streaming - protected route
-	mux.Handle("GET /logs/stream", protectedChain.ThenFunc(
+	mux.Handle(
+		"DELETE /users/{id}",
+		protectedChain.Use(middleware.DenyImpersonation).Use(
+			middleware.RequireSelfOrPermission("id", auth.PermissionUsersWrite),
+		).ThenFunc(DeleteUser(
+			config.Db,
+			config.Redis,
+			config.DeletionGracePeriod,
+			config.Cookies,
+		)),
+	)
+
+	// Admin routes - protected chain plus a permission check and the scope of
+	// the same name
+	mux.Handle(
+		"GET /users",
+		protectedChain.Use(
+			middleware.RequirePermission(auth.PermissionUsersRead),
+		).Use(
+			middleware.RequireScope(auth.PermissionUsersRead),
+		).ThenFunc(ListUsers(config.Db)),
+	)
+	mux.Handle(
+		"DELETE /users/{id}/sessions",
+		protectedChain.Use(
+			middleware.RequireSelfOrPermission("id", auth.PermissionUsersWrite),
+		).ThenFunc(RevokeUserSessions(config.Db, config.Redis)),
+	)
+	usersWriteChain := protectedChain.Use(
+		middleware.RequirePermission(auth.PermissionUsersWrite),
+	).Use(
+		middleware.RequireScope(auth.PermissionUsersWrite),
+	)
+	mux.Handle("GET /lockouts", usersWriteChain.ThenFunc(GetLockout(config.Redis)))
+	mux.Handle("DELETE /lockouts", usersWriteChain.ThenFunc(DeleteLockout(config.Redis)))
+	mux.Handle(
+		"PUT /users/{id}/suspension",
+		usersWriteChain.ThenFunc(SuspendUser(config.Db, config.Redis)),
+	)
+	mux.Handle("DELETE /users/{id}/suspension", usersWriteChain.ThenFunc(UnsuspendUser(config.Db)))
+	mux.Handle("POST /users/{id}/restore", usersWriteChain.ThenFunc(RestoreUser(config.Db)))
+	rolesChain := protectedChain.Use(
+		middleware.RequirePermission(auth.PermissionRolesManage),
+	).Use(
+		middleware.RequireScope(auth.PermissionRolesManage),
+	)
+	mux.Handle("PUT /users/{id}/roles/{role}", rolesChain.ThenFunc(GrantRole(config.Db)))
+	mux.Handle(
+		"DELETE /users/{id}/roles/{role}",
+		rolesChain.ThenFunc(RevokeRole(config.Db, config.Redis)),
+	)
+	clientsChain := protectedChain.Use(
+		middleware.RequirePermission(auth.PermissionClientsManage),
+	).Use(
+		middleware.RequireScope(auth.PermissionClientsManage),
+	)
+	mux.Handle("GET /clients", clientsChain.ThenFunc(ListClients(config.Db)))
+	mux.Handle("POST /clients", clientsChain.ThenFunc(CreateClient(config.Db)))
+	mux.Handle("DELETE /clients/{id}", clientsChain.ThenFunc(DeleteClient(config.Db)))
+	mux.Handle("GET /audit", protectedChain.Use(
+		middleware.RequirePermission(auth.PermissionAuditRead),
+	).Use(
+		middleware.RequireScope(auth.PermissionAuditRead),
+	).ThenFunc(
+		ListAudit(config.Db),
+	))
+
+	// SSE log streaming - admin route. EventSource cannot send headers, so the
+	// stream is opened with a single-use ticket from the protected route.
+	mux.Handle("POST /logs/stream/ticket", protectedChain.Use(
+		middleware.RequirePermission(auth.PermissionLogsStream),
+	).Use(
+		middleware.RequireScope(auth.PermissionLogsStream),
+	).ThenFunc(
+		CreateStreamTicket(config.Redis),
+	))
+	mux.Handle("GET /logs/stream", baseChain.Use(
+		middleware.RequireStreamTicket(config.Redis),
+	).Use(
+		middleware.RequirePermission(auth.PermissionLogsStream),
+	).Use(
+		middleware.RequireScope(auth.PermissionLogsStream),
+	).ThenFunc(
 		LogsStream(config.LogManager, config.Broadcaster),
 	))
 
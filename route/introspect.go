@@ -0,0 +1,391 @@
+package route
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/middleware"
+	"citadel/internal/oauth"
+	"citadel/internal/user"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// Token types reported by introspection.
+const (
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+	tokenTypeAPI     = "api_token"
+)
+
+// authenticateConfidentialClient identifies a client calling introspection
+// or revocation. Only clients holding a secret may, since both endpoints
+// reveal or change the state of tokens issued to anyone.
+func authenticateConfidentialClient(r *http.Request, db *sqlx.DB) (*oauth.Client, error) {
+	client, err := authenticateClient(r, db)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Confidential() {
+		return nil, errors.New("public clients cannot use this endpoint")
+	}
+	return client, nil
+}
+
+// Introspect reports whether a token is currently active, following RFC 7662.
+// Unlike a signature check it sees revocations: blacklisted tokens, bumped
+// token epochs, rotated out refresh tokens and suspended accounts.
+// token_type_hint is accepted but not needed, as every token type can be told
+// apart on sight.
+func Introspect(db *sqlx.DB, rdb *redis.Client, issuer *auth.Issuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("introspect handler started")
+
+		ctx := r.Context()
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		if err := r.ParseForm(); err != nil {
+			log.Warn("introspect failed: invalid form body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		client, err := authenticateConfidentialClient(r, db)
+		if err != nil {
+			log.Warn("introspect failed: client authentication failed", "error", err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="citadel"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			log.Warn("introspect failed: missing token")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		response, err := introspectToken(ctx, db, rdb, issuer, token)
+		if err != nil {
+			log.Error("failed to introspect token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "temporarily_unavailable"})
+			return
+		}
+		if response == nil {
+			response = map[string]any{"active": false}
+		}
+
+		log.Info(
+			"introspect handler completed successfully",
+			"client_id", client.ClientID,
+			"active", response["active"],
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// introspectToken returns the RFC 7662 response for an active token, or nil
+// for a token that is unknown, expired or revoked.
+func introspectToken(
+	ctx context.Context,
+	db *sqlx.DB,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	token string,
+) (map[string]any, error) {
+	if strings.HasPrefix(token, user.APITokenPrefix) {
+		return introspectAPIToken(ctx, db, token)
+	}
+	if claims, err := issuer.Validate(token); err == nil {
+		return introspectAccessToken(ctx, db, rdb, claims)
+	}
+	if claims, err := issuer.ValidateClientAccessToken(token); err == nil {
+		return introspectClientAccessToken(ctx, db, rdb, claims)
+	}
+	return introspectRefreshToken(ctx, db, rdb, token)
+}
+
+func introspectAccessToken(
+	ctx context.Context,
+	db *sqlx.DB,
+	rdb *redis.Client,
+	claims *auth.Claims,
+) (map[string]any, error) {
+	isBlacklisted, err := cache.IsBlacklisted(ctx, rdb, claims.ID)
+	if err != nil || isBlacklisted {
+		return nil, err
+	}
+	epoch, err := middleware.TokenEpoch(ctx, rdb, db, claims.UserId)
+	if errors.Is(err, user.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil || claims.TokenEpoch < epoch {
+		return nil, err
+	}
+
+	response := registeredClaims(tokenTypeAccess, claims.RegisteredClaims)
+	response["username"] = claims.Username
+	response["scope"] = claims.Scope
+	if claims.Impersonated() {
+		response["act"] = claims.Actor
+	}
+	return response, nil
+}
+
+func introspectClientAccessToken(
+	ctx context.Context,
+	db *sqlx.DB,
+	rdb *redis.Client,
+	claims *auth.ClientClaims,
+) (map[string]any, error) {
+	isBlacklisted, err := cache.IsBlacklisted(ctx, rdb, claims.ID)
+	if err != nil || isBlacklisted {
+		return nil, err
+	}
+	u, err := activeUser(ctx, db, claims.UserId)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	response := registeredClaims(tokenTypeAccess, claims.RegisteredClaims)
+	response["username"] = u.Username
+	response["client_id"] = claims.ClientID
+	response["scope"] = claims.Scope
+	return response, nil
+}
+
+func introspectRefreshToken(
+	ctx context.Context,
+	db *sqlx.DB,
+	rdb *redis.Client,
+	token string,
+) (map[string]any, error) {
+	refresh, expiresAt, err := cache.LookupRefresh(ctx, rdb, token)
+	if errors.Is(err, cache.ErrRefreshNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u, err := activeUser(ctx, db, refresh.UserID)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"active":     true,
+		"token_type": tokenTypeRefresh,
+		"sub":        strconv.FormatInt(u.UserId, 10),
+		"username":   u.Username,
+		"exp":        expiresAt.Unix(),
+	}, nil
+}
+
+func introspectAPIToken(ctx context.Context, db *sqlx.DB, token string) (map[string]any, error) {
+	t, err := user.LookupAPIToken(ctx, db, token)
+	if errors.Is(err, user.ErrAPITokenInvalid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u, err := activeUser(ctx, db, t.UserId)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	response := map[string]any{
+		"active":     true,
+		"token_type": tokenTypeAPI,
+		"sub":        strconv.FormatInt(u.UserId, 10),
+		"username":   u.Username,
+		"iat":        t.CreatedAt.Unix(),
+	}
+	if scopes := t.ScopeList(); len(scopes) > 0 {
+		response["scope"] = strings.Join(scopes, " ")
+	}
+	if t.ExpiresAt != nil {
+		response["exp"] = t.ExpiresAt.Unix()
+	}
+	return response, nil
+}
+
+// activeUser returns the user, or nil if they no longer exist or are suspended.
+func activeUser(ctx context.Context, db *sqlx.DB, userID int64) (*user.User, error) {
+	u, err := user.ByID(ctx, db, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.SuspendedAt != nil {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// registeredClaims returns the introspection fields shared by every JWT.
+func registeredClaims(tokenType string, claims jwt.RegisteredClaims) map[string]any {
+	response := map[string]any{
+		"active":     true,
+		"token_type": tokenType,
+		"sub":        claims.Subject,
+		"jti":        claims.ID,
+		"iss":        claims.Issuer,
+		"aud":        claims.Audience,
+	}
+	if claims.ExpiresAt != nil {
+		response["exp"] = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response["iat"] = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		response["nbf"] = claims.NotBefore.Unix()
+	}
+	return response
+}
+
+// Revoke revokes a token, following RFC 7009. Access tokens are blacklisted
+// until they expire and refresh tokens end their whole session. Personal
+// access tokens are managed by their owner and cannot be revoked here.
+// Unknown and already invalid tokens are not an error.
+func Revoke(db *sqlx.DB, rdb *redis.Client, issuer *auth.Issuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("revoke handler started")
+
+		ctx := r.Context()
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		if err := r.ParseForm(); err != nil {
+			log.Warn("revoke failed: invalid form body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		client, err := authenticateConfidentialClient(r, db)
+		if err != nil {
+			log.Warn("revoke failed: client authentication failed", "error", err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="citadel"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			log.Warn("revoke failed: missing token")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		if strings.HasPrefix(token, user.APITokenPrefix) {
+			log.Warn("revoke failed: api tokens cannot be revoked by clients")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_token_type"})
+			return
+		}
+
+		var (
+			tokenType string
+			userID    int64
+			jti       string
+			expiresAt time.Time
+			details   = map[string]any{"client_id": client.ClientID}
+		)
+		if claims, err := issuer.Validate(token); err == nil {
+			tokenType, userID = tokenTypeAccess, claims.UserId
+			jti, expiresAt = claims.ID, claims.ExpiresAt.Time
+		} else if claims, err := issuer.ValidateClientAccessToken(token); err == nil {
+			// A client may only revoke the tokens issued to it
+			if claims.ClientID != client.ClientID {
+				log.Warn(
+					"revoke failed: token issued to another client",
+					"client_id", client.ClientID,
+				)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized_client"})
+				return
+			}
+			tokenType, userID = tokenTypeAccess, claims.UserId
+			jti, expiresAt = claims.ID, claims.ExpiresAt.Time
+		} else {
+			refresh, _, err := cache.LookupRefresh(ctx, rdb, token)
+			if err != nil && !errors.Is(err, cache.ErrRefreshNotFound) {
+				log.Error("failed to look up refresh token", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(map[string]string{"error": "temporarily_unavailable"})
+				return
+			}
+			if err == nil {
+				tokenType, userID = tokenTypeRefresh, refresh.UserID
+				details["session_id"] = refresh.FamilyID
+				if _, err := cache.RevokeFamily(ctx, rdb, refresh.FamilyID); err != nil {
+					log.Error("failed to revoke session", "error", err)
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					json.NewEncoder(w).
+						Encode(map[string]string{"error": "temporarily_unavailable"})
+					return
+				}
+			}
+		}
+
+		if ttl := time.Until(expiresAt); jti != "" && ttl > 0 {
+			details["jti"] = jti
+			if err := cache.Blacklist(ctx, rdb, jti, ttl); err != nil {
+				log.Error("failed to blacklist token", "error", err, "jti", jti)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(map[string]string{"error": "temporarily_unavailable"})
+				return
+			}
+		}
+
+		if tokenType != "" {
+			details["token_type"] = tokenType
+			recordAudit(r, db, audit.EventTokenRevoke, 0, userID, details)
+		}
+
+		log.Info(
+			"revoke handler completed successfully",
+			"client_id", client.ClientID,
+			"token_type", tokenType,
+		)
+		w.WriteHeader(http.StatusOK)
+	}
+}
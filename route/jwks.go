@@ -0,0 +1,18 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"citadel/internal/auth"
+)
+
+// GetJWKS publishes the public verification keys for other services.
+func GetJWKS(issuer *auth.Issuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(issuer.JWKS())
+	}
+}
@@ -1,18 +1,60 @@
 package route
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"citadel/internal/cache"
 	"citadel/internal/logging"
 	"citadel/internal/middleware"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
+// streamTicketTTL is how long a client has to open the stream with a ticket.
+const streamTicketTTL = 30 * time.Second
+
+// CreateStreamTicket mints a single-use ticket that opens one log stream on
+// behalf of the authenticated user.
+func CreateStreamTicket(rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("create stream ticket handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("create stream ticket failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		ticket := rand.Text()
+		err := cache.SaveStreamTicket(r.Context(), rdb, ticket, claims, streamTicketTTL)
+		if err != nil {
+			log.Error("failed to store stream ticket", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create stream ticket"})
+			return
+		}
+
+		log.Info("create stream ticket handler completed successfully", "user_id", claims.UserId)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"ticket":     ticket,
+			"expires_in": int(streamTicketTTL.Seconds()),
+		})
+	}
+}
+
 // LogsStream returns an SSE handler for streaming logs.
 func LogsStream(manager *logging.Manager, broadcaster *logging.Broadcaster) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,302 @@
+package route
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/mail"
+	"citadel/internal/mfa"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// magicLinkTTL is how long a magic login link stays valid.
+	magicLinkTTL = 15 * time.Minute
+	// magicLinkLimit is how many links one address may be sent per window.
+	magicLinkLimit  = 3
+	magicLinkWindow = time.Hour
+)
+
+// RequestMagicLink emails a single-use sign-in link. The response carries a
+// nonce that must be presented along with the link, so the link only works
+// in the browser that asked for it. As with password resets, the response is
+// the same whether or not the address has an account.
+func RequestMagicLink(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	mailer mail.Mailer,
+	appURL string,
+) http.HandlerFunc {
+	type Request struct {
+		Email string `json:"email"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("request magic link handler started")
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			log.Warn("request magic link validation failed: missing email")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Email is required"})
+			return
+		}
+
+		count, retryAfter, err := cache.CountMagicLinkRequest(
+			r.Context(),
+			rdb,
+			req.Email,
+			magicLinkWindow,
+		)
+		if err != nil {
+			log.Error("failed to count magic link request", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Authentication service unavailable"})
+			return
+		}
+		if count > magicLinkLimit {
+			log.Warn("magic link requests throttled", "email", req.Email, "count", count)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Too many sign-in links requested, try again later",
+			})
+			return
+		}
+
+		nonce := rand.Text()
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			u, err := user.ByEmail(ctx, db, req.Email)
+			if err != nil {
+				log.Info("magic link requested for unknown email", "email", req.Email)
+				return
+			}
+			if u.SuspendedAt != nil {
+				log.Info("magic link requested for suspended account", "user_id", u.UserId)
+				return
+			}
+
+			token, _, err := issuer.GeneratePurposeToken(
+				auth.PurposeMagicLink,
+				u.UserId,
+				u.Email,
+				magicLinkTTL,
+			)
+			if err != nil {
+				log.Error("failed to generate magic link token", "error", err)
+				return
+			}
+			err = cache.SaveMagicLink(ctx, rdb, token, nonce, u.UserId, magicLinkTTL)
+			if err != nil {
+				log.Error("failed to store magic link", "error", err, "user_id", u.UserId)
+				return
+			}
+
+			link := fmt.Sprintf("%s/login/magic?token=%s", appURL, url.QueryEscape(token))
+			err = mailer.Send(ctx, mail.Message{
+				To:      u.Email,
+				Subject: "Your sign-in link",
+				Body: fmt.Sprintf(
+					"Hi %s,\n\n"+
+						"Sign in to Citadel by opening this link in the same browser "+
+						"you asked for it from:\n\n"+
+						"%s\n\n"+
+						"The link expires in 15 minutes and can only be used once. "+
+						"If you did not ask for this, you can ignore this email.\n",
+					u.Username,
+					link,
+				),
+			})
+			if err != nil {
+				log.Error("failed to send magic link email", "error", err, "user_id", u.UserId)
+				return
+			}
+			log.Info("magic link email sent", "user_id", u.UserId)
+		}()
+
+		log.Info("request magic link handler completed")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an account exists for that email, a sign-in link has been sent",
+			"nonce":   nonce,
+		})
+	}
+}
+
+// VerifyMagicLink signs the user in with a magic link and the nonce from the
+// browser that requested it. Opening the link proves the user owns their
+// address, so it also verifies the email.
+func VerifyMagicLink(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	cookies CookieConfig,
+) http.HandlerFunc {
+	type Request struct {
+		Token string `json:"token"`
+		Nonce string `json:"nonce"`
+		Scope string `json:"scope"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("verify magic link handler started")
+
+		ctx := r.Context()
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+			req.Token == "" || req.Nonce == "" {
+			log.Warn("verify magic link validation failed: missing fields")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Token and nonce are required"})
+			return
+		}
+
+		claims, err := issuer.ValidatePurposeToken(auth.PurposeMagicLink, req.Token)
+		if err != nil {
+			log.Warn("invalid magic link token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired sign-in link"})
+			return
+		}
+
+		userID, err := cache.ConsumeMagicLink(ctx, rdb, req.Token, req.Nonce)
+		if errors.Is(err, cache.ErrMagicLinkNotFound) || errors.Is(err, cache.ErrMagicLinkNonce) ||
+			(err == nil && userID != claims.UserId) {
+			log.Warn("failed login attempt: invalid magic link",
+				"user_id", claims.UserId,
+				"error", err,
+			)
+			recordAudit(r, db, audit.EventLoginFailure, 0, claims.UserId, map[string]any{
+				"reason": "invalid_magic_link",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired sign-in link"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to consume magic link", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Authentication service unavailable"})
+			return
+		}
+
+		u, err := user.ByID(ctx, db, userID)
+		// A link sent before an email change must not sign in to the new address
+		if err != nil || u.Email != claims.Email {
+			log.Warn("magic link login failed: account changed", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired sign-in link"})
+			return
+		}
+
+		if u.SuspendedAt != nil {
+			log.Warn("login blocked: account suspended", "user_id", u.UserId)
+			recordAudit(r, db, audit.EventLoginFailure, 0, u.UserId, map[string]any{
+				"reason": "suspended",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Account suspended"})
+			return
+		}
+
+		if u.EmailVerifiedAt == nil {
+			log.Info("marking email verified", "user_id", u.UserId)
+			if err := user.MarkEmailVerified(ctx, db, u.UserId, u.Email); err != nil {
+				log.Error("failed to verify email", "error", err, "user_id", u.UserId)
+			}
+		}
+
+		mfaEnabled, err := mfa.IsEnabled(ctx, db, u.UserId)
+		if err != nil {
+			log.Error("failed to check mfa enrollment", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Authentication error"})
+			return
+		}
+		if mfaEnabled {
+			log.Info("magic link verified, issuing mfa challenge", "user_id", u.UserId)
+			mfaToken, _, err := issuer.GenerateMFAToken(
+				u.UserId,
+				"magic_link",
+				req.Scope,
+				mfaChallengeTTL,
+			)
+			if err != nil {
+				log.Error("failed to generate mfa token", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]any{
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+			})
+			return
+		}
+
+		log.Info("starting session", "user_id", u.UserId)
+		accessToken, refreshToken, err := startSession(
+			ctx,
+			db,
+			rdb,
+			issuer,
+			u,
+			req.Scope,
+			deviceFrom(r),
+		)
+		if errors.Is(err, auth.ErrInvalidScope) {
+			log.Warn("verify magic link validation failed: invalid scope", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Unknown or unavailable scope"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to start session", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventLoginSuccess, u.UserId, u.UserId, map[string]any{
+			"method": "magic_link",
+		})
+		log.Info("verify magic link handler completed successfully", "user_id", u.UserId)
+		writeSession(w, r, cookies, http.StatusOK, accessToken, refreshToken)
+	}
+}
@@ -26,10 +26,16 @@ func GetMe() http.HandlerFunc {
 		log.Info("get me handler completed successfully", "user_id", claims.UserId)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]any{
+		response := map[string]any{
 			"user_id":  claims.UserId,
 			"email":    claims.Email,
 			"username": claims.Username,
-		})
+			"roles":    claims.Roles,
+			"scope":    claims.Scope,
+		}
+		if claims.Impersonated() {
+			response["impersonator"] = claims.Actor
+		}
+		json.NewEncoder(w).Encode(response)
 	}
 }
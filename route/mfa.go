@@ -0,0 +1,489 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/mfa"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// mfaIssuer is the account issuer shown in authenticator apps.
+	mfaIssuer = "Citadel"
+	// mfaChallengeTTL is how long a user has to enter their code after the password step.
+	mfaChallengeTTL = 5 * time.Minute
+	// maxMFAAttempts is how many codes a single challenge accepts for checking.
+	maxMFAAttempts = 5
+)
+
+// verifySecondFactor checks either a TOTP code or a recovery code.
+func verifySecondFactor(
+	ctx context.Context,
+	db *sqlx.DB,
+	userID int64,
+	code string,
+	recoveryCode string,
+) (bool, error) {
+	if recoveryCode != "" {
+		return mfa.UseRecoveryCode(ctx, db, userID, recoveryCode)
+	}
+	return mfa.Verify(ctx, db, userID, code)
+}
+
+func EnrollTOTP(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("enroll totp handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("enroll totp failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		secret, err := mfa.GenerateSecret()
+		if err != nil {
+			log.Error("failed to generate totp secret", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to enroll"})
+			return
+		}
+
+		log.Info("storing unconfirmed totp secret", "user_id", claims.UserId)
+		if err := mfa.Enroll(ctx, db, claims.UserId, secret); err != nil {
+			if errors.Is(err, mfa.ErrAlreadyEnabled) {
+				log.Warn("enroll totp failed: already enabled", "user_id", claims.UserId)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).
+					Encode(map[string]string{"error": "Two-factor authentication already enabled"})
+				return
+			}
+			log.Error("failed to enroll totp", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to enroll"})
+			return
+		}
+
+		log.Info("enroll totp handler completed successfully", "user_id", claims.UserId)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"secret":           secret,
+			"provisioning_uri": mfa.ProvisioningURI(mfaIssuer, claims.Email, secret),
+		})
+	}
+}
+
+func ConfirmTOTP(db *sqlx.DB) http.HandlerFunc {
+	type Request struct {
+		Code string `json:"code"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("confirm totp handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("confirm totp failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			log.Warn("confirm totp validation failed: missing code")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Code is required"})
+			return
+		}
+
+		t, err := mfa.ByUser(ctx, db, claims.UserId)
+		if errors.Is(err, mfa.ErrNotEnrolled) {
+			log.Warn("confirm totp failed: not enrolled", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "No pending enrollment"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to get totp enrollment", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to confirm"})
+			return
+		}
+
+		step, valid := mfa.Validate(t.Secret, req.Code, time.Now())
+		if !valid {
+			log.Warn("confirm totp failed: invalid code", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid code"})
+			return
+		}
+
+		log.Info("confirming totp enrollment", "user_id", claims.UserId)
+		codes, err := mfa.Confirm(ctx, db, claims.UserId, step)
+		if err != nil {
+			if errors.Is(err, mfa.ErrAlreadyEnabled) {
+				log.Warn("confirm totp failed: already enabled", "user_id", claims.UserId)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).
+					Encode(map[string]string{"error": "Two-factor authentication already enabled"})
+				return
+			}
+			log.Error("failed to confirm totp", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to confirm"})
+			return
+		}
+
+		log.Info("confirm totp handler completed successfully", "user_id", claims.UserId)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+	}
+}
+
+func DisableTOTP(db *sqlx.DB) http.HandlerFunc {
+	type Request struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("disable totp handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("disable totp failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+			(req.Code == "" && req.RecoveryCode == "") {
+			log.Warn("disable totp validation failed: missing code")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Code or recovery code is required"})
+			return
+		}
+
+		valid, err := verifySecondFactor(ctx, db, claims.UserId, req.Code, req.RecoveryCode)
+		if errors.Is(err, mfa.ErrNotEnrolled) {
+			log.Warn("disable totp failed: not enrolled", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Two-factor authentication is not enabled"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to verify second factor", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to disable"})
+			return
+		}
+		if !valid {
+			log.Warn("disable totp failed: invalid code", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid code"})
+			return
+		}
+
+		log.Info("disabling totp", "user_id", claims.UserId)
+		if err := mfa.Disable(ctx, db, claims.UserId); err != nil {
+			log.Error("failed to disable totp", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to disable"})
+			return
+		}
+
+		log.Info("disable totp handler completed successfully", "user_id", claims.UserId)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func RegenerateRecoveryCodes(db *sqlx.DB) http.HandlerFunc {
+	type Request struct {
+		Code string `json:"code"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("regenerate recovery codes handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("regenerate recovery codes failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			log.Warn("regenerate recovery codes validation failed: missing code")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Code is required"})
+			return
+		}
+
+		valid, err := mfa.Verify(ctx, db, claims.UserId, req.Code)
+		if errors.Is(err, mfa.ErrNotEnrolled) {
+			log.Warn("regenerate recovery codes failed: not enrolled", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Two-factor authentication is not enabled"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to verify totp code", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+		if !valid {
+			log.Warn("regenerate recovery codes failed: invalid code", "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid code"})
+			return
+		}
+
+		codes, err := mfa.RegenerateRecoveryCodes(ctx, db, claims.UserId)
+		if err != nil {
+			log.Error("failed to regenerate recovery codes", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+
+		log.Info(
+			"regenerate recovery codes handler completed successfully",
+			"user_id",
+			claims.UserId,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+	}
+}
+
+// LoginMFA completes a login that stopped at the MFA challenge.
+func LoginMFA(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	cookies CookieConfig,
+) http.HandlerFunc {
+	type Request struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("login mfa handler started")
+
+		ctx := r.Context()
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Error("failed to decode login mfa request", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+			log.Warn("login mfa validation failed: missing fields")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "MFA token and code are required"})
+			return
+		}
+
+		challenge, err := issuer.ValidatePurposeToken(auth.PurposeMFA, req.MFAToken)
+		if err != nil {
+			log.Warn("invalid mfa token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		used, err := cache.IsBlacklisted(ctx, rdb, challenge.ID)
+		if err != nil {
+			log.Error("failed to check mfa token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Authentication service unavailable"})
+			return
+		}
+		if used {
+			log.Warn("mfa token already used", "user_id", challenge.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		u, err := user.ByID(ctx, db, challenge.UserId)
+		if err != nil {
+			log.Error("failed to fetch user", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+
+		// Wrong codes count against the same keys as wrong passwords, so fresh
+		// challenges do not buy an attacker who knows the password more guesses
+		throttleKeys := loginThrottleKeys(r, u.Email)
+		if !checkLoginThrottle(w, r, rdb, throttleKeys) {
+			return
+		}
+
+		ttl := time.Until(challenge.ExpiresAt.Time)
+		attempts, err := cache.RecordMFAAttempt(ctx, rdb, challenge.ID, ttl)
+		if err != nil {
+			log.Error("failed to record mfa attempt", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Authentication service unavailable"})
+			return
+		}
+		if attempts > maxMFAAttempts {
+			log.Warn("mfa challenge exhausted", "user_id", challenge.UserId)
+			if err := cache.Blacklist(ctx, rdb, challenge.ID, ttl); err != nil {
+				log.Error("failed to blacklist mfa token", "error", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		log.Info("verifying second factor", "user_id", challenge.UserId)
+		valid, err := verifySecondFactor(ctx, db, challenge.UserId, req.Code, req.RecoveryCode)
+		if err != nil && !errors.Is(err, mfa.ErrNotEnrolled) {
+			log.Error("failed to verify second factor", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Authentication error"})
+			return
+		}
+		if !valid {
+			log.Warn("failed login attempt: invalid mfa code", "user_id", challenge.UserId)
+			recordAudit(r, db, audit.EventLoginFailure, 0, challenge.UserId, map[string]any{
+				"reason": "invalid_mfa_code",
+			})
+			recordLoginFailure(ctx, log, rdb, throttleKeys)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid code"})
+			return
+		}
+
+		// The challenge is single use
+		if err := cache.Blacklist(ctx, rdb, challenge.ID, ttl); err != nil {
+			log.Error("failed to blacklist mfa token", "error", err)
+		}
+
+		if u.SuspendedAt != nil {
+			log.Warn("login blocked: account suspended", "user_id", u.UserId)
+			recordAudit(r, db, audit.EventLoginFailure, 0, u.UserId, map[string]any{
+				"reason": "suspended",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Account suspended"})
+			return
+		}
+
+		log.Info("starting session", "user_id", u.UserId)
+		accessToken, refreshToken, err := startSession(
+			ctx,
+			db,
+			rdb,
+			issuer,
+			u,
+			challenge.Scope,
+			deviceFrom(r),
+		)
+		if errors.Is(err, auth.ErrInvalidScope) {
+			log.Warn("login mfa validation failed: invalid scope", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Unknown or unavailable scope"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to start session", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+			return
+		}
+
+		_, err = cache.ResetThrottle(ctx, rdb, emailThrottleKey(u.Email))
+		if err != nil {
+			log.Error("failed to reset login throttle", "error", err)
+		}
+
+		secondFactor := "totp"
+		if req.RecoveryCode != "" {
+			secondFactor = "recovery_code"
+		}
+		recordAudit(r, db, audit.EventLoginSuccess, u.UserId, u.UserId, map[string]any{
+			"method": challenge.Factor + "+" + secondFactor,
+		})
+		log.Info("login mfa handler completed successfully", "user_id", u.UserId)
+		writeSession(w, r, cookies, http.StatusOK, accessToken, refreshToken)
+	}
+}
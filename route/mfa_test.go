@@ -0,0 +1,138 @@
+package route
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/mfa"
+)
+
+const totpSecret = "JBSWY3DPEHPK3PXP"
+
+// totpCode computes the current RFC 6238 code for totpSecret.
+func totpCode(t *testing.T) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(totpSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func TestLoginMFAAuditMethod(t *testing.T) {
+	tests := []struct {
+		name string
+		// challenge returns an MFA token for the user after the first factor
+		challenge  func(t *testing.T, s *testServer, id int64) string
+		recovery   bool
+		wantMethod string
+	}{
+		{
+			name:       "password and totp",
+			challenge:  passwordChallenge,
+			wantMethod: "password+totp",
+		},
+		{
+			name:       "password and recovery code",
+			challenge:  passwordChallenge,
+			recovery:   true,
+			wantMethod: "password+recovery_code",
+		},
+		{
+			name:       "magic link and totp",
+			challenge:  magicLinkChallenge,
+			wantMethod: "magic_link+totp",
+		},
+		{
+			name:       "magic link and recovery code",
+			challenge:  magicLinkChallenge,
+			recovery:   true,
+			wantMethod: "magic_link+recovery_code",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			id := userID(t, s, registerUser(t, s, "alice", "alice@example.com").AccessToken)
+			ctx := context.Background()
+			if err := mfa.Enroll(ctx, s.cfg.Db, id, totpSecret); err != nil {
+				t.Fatal(err)
+			}
+			codes, err := mfa.Confirm(ctx, s.cfg.Db, id, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := map[string]string{"mfa_token": tt.challenge(t, s, id)}
+			if tt.recovery {
+				body["recovery_code"] = codes[0]
+			} else {
+				body["code"] = totpCode(t)
+			}
+			s.mustDo(t, http.StatusOK, "POST", "/login/mfa", "", body, nil)
+
+			var details string
+			err = s.cfg.Db.Get(
+				&details,
+				`SELECT details FROM audit_events WHERE event_type = ?
+				ORDER BY event_id DESC LIMIT 1`,
+				audit.EventLoginSuccess,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var event struct {
+				Method string `json:"method"`
+			}
+			if err := json.Unmarshal([]byte(details), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Method != tt.wantMethod {
+				t.Errorf("got method %q, want %q", event.Method, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func passwordChallenge(t *testing.T, s *testServer, _ int64) string {
+	t.Helper()
+
+	var challenge struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	credentials := map[string]string{
+		"email":    "alice@example.com",
+		"password": "correct horse battery staple",
+	}
+	s.mustDo(t, http.StatusOK, "POST", "/login", "", credentials, &challenge)
+	return challenge.MFAToken
+}
+
+// magicLinkChallenge mints the challenge a magic link sign-in ends with, as
+// the link itself is only sent by email.
+func magicLinkChallenge(t *testing.T, s *testServer, id int64) string {
+	t.Helper()
+
+	token, _, err := s.cfg.Issuer.GenerateMFAToken(id, "magic_link", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,550 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/middleware"
+	"citadel/internal/oauth"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// authCodeTTL is how long a client has to exchange an authorization code.
+	authCodeTTL = time.Minute
+	// clientTokenTTL is the lifetime of access and ID tokens issued to clients.
+	clientTokenTTL = 15 * time.Minute
+)
+
+// authorizeParams is an authorization request as sent by a client.
+type authorizeParams struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// oauthError is an RFC 6749 error. Errors found after the client and redirect
+// URI are known go back to the client; the rest are shown to the user.
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+// checkAuthorize validates an authorization request. A nil client means the
+// request cannot be trusted enough to redirect back.
+func checkAuthorize(
+	r *http.Request,
+	db *sqlx.DB,
+	params authorizeParams,
+) (*oauth.Client, *oauthError) {
+	client, err := oauth.ClientByID(r.Context(), db, params.ClientID)
+	if err != nil {
+		return nil, &oauthError{"invalid_client", "Unknown client"}
+	}
+	if !client.AllowsRedirect(params.RedirectURI) {
+		return nil, &oauthError{"invalid_request", "Redirect URI is not registered"}
+	}
+
+	if params.ResponseType != "code" {
+		return client, &oauthError{
+			"unsupported_response_type",
+			"Only the authorization code flow is supported",
+		}
+	}
+	if !oauth.HasScope(params.Scope, oauth.ScopeOpenID) {
+		return client, &oauthError{"invalid_scope", "The openid scope is required"}
+	}
+	if params.CodeChallengeMethod != oauth.PKCEMethodS256 ||
+		!oauth.ValidChallenge(params.CodeChallenge) {
+		return client, &oauthError{"invalid_request", "PKCE with S256 is required"}
+	}
+	return client, nil
+}
+
+// redirectWith appends parameters to a client's redirect URI.
+func redirectWith(redirectURI string, params map[string]string) string {
+	u, _ := url.Parse(redirectURI)
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Authorize is where clients send the browser. Citadel has no UI of its own,
+// so a valid request is handed to the web app's consent page, which shows it
+// to the signed-in user and posts the decision to ApproveAuthorization.
+func Authorize(db *sqlx.DB, appURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("authorize handler started")
+
+		q := r.URL.Query()
+		params := authorizeParams{
+			ResponseType:        q.Get("response_type"),
+			ClientID:            q.Get("client_id"),
+			RedirectURI:         q.Get("redirect_uri"),
+			Scope:               q.Get("scope"),
+			State:               q.Get("state"),
+			Nonce:               q.Get("nonce"),
+			CodeChallenge:       q.Get("code_challenge"),
+			CodeChallengeMethod: q.Get("code_challenge_method"),
+		}
+
+		client, oerr := checkAuthorize(r, db, params)
+		if client == nil {
+			log.Warn("authorize failed", "error", oerr.Code, "client_id", params.ClientID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":             oerr.Code,
+				"error_description": oerr.Description,
+			})
+			return
+		}
+		if oerr != nil {
+			log.Warn("authorize failed", "error", oerr.Code, "client_id", client.ClientID)
+			http.Redirect(w, r, redirectWith(params.RedirectURI, map[string]string{
+				"error":             oerr.Code,
+				"error_description": oerr.Description,
+				"state":             params.State,
+			}), http.StatusFound)
+			return
+		}
+
+		log.Info("authorize handler completed successfully", "client_id", client.ClientID)
+		http.Redirect(w, r, appURL+"/oauth/authorize?"+r.URL.RawQuery, http.StatusFound)
+	}
+}
+
+// ApproveAuthorization records the signed-in user's decision on an
+// authorization request and returns where to send the browser next.
+func ApproveAuthorization(db *sqlx.DB, rdb *redis.Client) http.HandlerFunc {
+	type Request struct {
+		authorizeParams
+		Approve bool `json:"approve"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("approve authorization handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("approve authorization failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("approve authorization failed: invalid request body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		client, oerr := checkAuthorize(r, db, req.authorizeParams)
+		if oerr != nil {
+			log.Warn("approve authorization failed", "error", oerr.Code, "client_id", req.ClientID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":             oerr.Code,
+				"error_description": oerr.Description,
+			})
+			return
+		}
+
+		if !req.Approve {
+			log.Info(
+				"approve authorization handler completed: access denied",
+				"user_id", claims.UserId,
+				"client_id", client.ClientID,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{
+				"redirect_to": redirectWith(req.RedirectURI, map[string]string{
+					"error": "access_denied",
+					"state": req.State,
+				}),
+			})
+			return
+		}
+
+		// auth_time is when the user signed in, which is when the session began
+		authTime := claims.IssuedAt.Time
+		if session, err := cache.GetSession(ctx, rdb, claims.SessionID); err == nil {
+			authTime = session.CreatedAt
+		}
+
+		code, err := oauth.NewCode()
+		if err != nil {
+			log.Error("failed to generate authorization code", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to authorize client"})
+			return
+		}
+		err = cache.SaveAuthCode(ctx, rdb, code, cache.AuthCode{
+			ClientID:      client.ClientID,
+			UserID:        claims.UserId,
+			RedirectURI:   req.RedirectURI,
+			Scope:         strings.Join(oauth.ParseScope(req.Scope), " "),
+			Nonce:         req.Nonce,
+			CodeChallenge: req.CodeChallenge,
+			AuthTime:      authTime,
+		}, authCodeTTL)
+		if err != nil {
+			log.Error("failed to store authorization code", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to authorize client"})
+			return
+		}
+
+		log.Info(
+			"approve authorization handler completed successfully",
+			"user_id", claims.UserId,
+			"client_id", client.ClientID,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"redirect_to": redirectWith(req.RedirectURI, map[string]string{
+				"code":  code,
+				"state": req.State,
+			}),
+		})
+	}
+}
+
+// authenticateClient identifies the client at the token endpoint, from HTTP
+// Basic credentials or client_id/client_secret form fields. Public clients
+// send only their client_id.
+func authenticateClient(r *http.Request, db *sqlx.DB) (*oauth.Client, error) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes the credentials before Basic encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := oauth.ClientByID(r.Context(), db, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Confidential() != (secret != "") {
+		return nil, errors.New("client authentication method mismatch")
+	}
+	if client.Confidential() && !client.Authenticate(secret) {
+		return nil, errors.New("invalid client secret")
+	}
+	return client, nil
+}
+
+// Token exchanges an authorization code for an access token and ID token.
+func Token(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	issuerURL string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("token handler started")
+
+		ctx := r.Context()
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		if err := r.ParseForm(); err != nil {
+			log.Warn("token request failed: invalid form body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		client, err := authenticateClient(r, db)
+		if err != nil {
+			log.Warn("token request failed: client authentication failed", "error", err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="citadel"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		if grantType := r.PostForm.Get("grant_type"); grantType != "authorization_code" {
+			log.Warn("token request failed: unsupported grant type", "grant_type", grantType)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+			return
+		}
+
+		code, err := cache.ConsumeAuthCode(ctx, rdb, r.PostForm.Get("code"))
+		if errors.Is(err, cache.ErrCodeNotFound) {
+			log.Warn("token request failed: unknown authorization code")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to load authorization code", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "server_error"})
+			return
+		}
+
+		if code.ClientID != client.ClientID ||
+			code.RedirectURI != r.PostForm.Get("redirect_uri") ||
+			!oauth.VerifyPKCE(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+			log.Warn(
+				"token request failed: authorization code binding mismatch",
+				"client_id", client.ClientID,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		u, err := user.ByID(ctx, db, code.UserID)
+		if err != nil {
+			log.Warn("token request failed: user not found", "user_id", code.UserID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if u.SuspendedAt != nil {
+			log.Warn("token request failed: account suspended", "user_id", u.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		accessToken, _, err := issuer.GenerateClientAccessToken(
+			client.ClientID,
+			u.UserId,
+			code.Scope,
+			clientTokenTTL,
+		)
+		if err != nil {
+			log.Error("failed to generate client access token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "server_error"})
+			return
+		}
+
+		idClaims := auth.IDClaims{Nonce: code.Nonce, AuthTime: code.AuthTime.Unix()}
+		addUserClaims(&idClaims, u, code.Scope)
+		idToken, err := issuer.GenerateIDToken(
+			issuerURL,
+			client.ClientID,
+			idClaims,
+			u.UserId,
+			clientTokenTTL,
+		)
+		if err != nil {
+			log.Error("failed to generate id token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "server_error"})
+			return
+		}
+
+		// Signing in to a client counts as a login
+		if err := user.RecordLogin(ctx, db, u.UserId); err != nil {
+			log.Error("failed to record login", "error", err, "user_id", u.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "server_error"})
+			return
+		}
+
+		log.Info(
+			"token handler completed successfully",
+			"user_id", u.UserId,
+			"client_id", client.ClientID,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(clientTokenTTL.Seconds()),
+			"id_token":     idToken,
+			"scope":        code.Scope,
+		})
+	}
+}
+
+// addUserClaims fills in the user claims released by the granted scopes.
+func addUserClaims(claims *auth.IDClaims, u *user.User, scope string) {
+	if oauth.HasScope(scope, oauth.ScopeProfile) {
+		claims.PreferredUsername = u.Username
+	}
+	if oauth.HasScope(scope, oauth.ScopeEmail) {
+		verified := u.EmailVerifiedAt != nil
+		claims.Email = u.Email
+		claims.EmailVerified = &verified
+	}
+}
+
+// UserInfo returns the claims about the user that the client's token allows.
+func UserInfo(db *sqlx.DB, rdb *redis.Client, issuer *auth.Issuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("userinfo handler started")
+
+		ctx := r.Context()
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || token == "" {
+			log.Warn("userinfo failed: missing bearer token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="citadel"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		claims, err := issuer.ValidateClientAccessToken(token)
+		if err != nil {
+			log.Warn("userinfo failed: invalid token", "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="citadel", error="invalid_token"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_token"})
+			return
+		}
+
+		isBlacklisted, err := cache.IsBlacklisted(ctx, rdb, claims.ID)
+		if err != nil {
+			log.Error("failed to check token blacklist", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "temporarily_unavailable"})
+			return
+		}
+		if isBlacklisted {
+			log.Warn("userinfo failed: token has been revoked", "jti", claims.ID)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="citadel", error="invalid_token"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_token"})
+			return
+		}
+
+		// A suspended user's tokens are no longer active, as Introspect reports
+		u, err := activeUser(ctx, db, claims.UserId)
+		if err != nil {
+			log.Error("failed to fetch user", "error", err, "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "server_error"})
+			return
+		}
+		if u == nil {
+			log.Warn("userinfo failed: user not found or suspended", "user_id", claims.UserId)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="citadel", error="invalid_token"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_token"})
+			return
+		}
+
+		var info auth.IDClaims
+		addUserClaims(&info, u, claims.Scope)
+		info.Subject = claims.Subject
+
+		log.Info(
+			"userinfo handler completed successfully",
+			"user_id", u.UserId,
+			"client_id", claims.ClientID,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+// GetOpenIDConfiguration serves the OpenID Connect discovery document.
+func GetOpenIDConfiguration(issuer *auth.Issuer, issuerURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuerURL,
+			"authorization_endpoint":                issuerURL + "/authorize",
+			"token_endpoint":                        issuerURL + "/token",
+			"userinfo_endpoint":                     issuerURL + "/userinfo",
+			"introspection_endpoint":                issuerURL + "/introspect",
+			"revocation_endpoint":                   issuerURL + "/revoke",
+			"jwks_uri":                              issuerURL + "/.well-known/jwks.json",
+			"scopes_supported":                      oauth.SupportedScopes,
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 []string{"authorization_code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{issuer.SigningAlgorithm()},
+			"token_endpoint_auth_methods_supported": []string{
+				"client_secret_basic",
+				"client_secret_post",
+				"none",
+			},
+			// Introspection and revocation are for confidential clients only
+			"introspection_endpoint_auth_methods_supported": []string{
+				"client_secret_basic",
+				"client_secret_post",
+			},
+			"revocation_endpoint_auth_methods_supported": []string{
+				"client_secret_basic",
+				"client_secret_post",
+			},
+			"code_challenge_methods_supported": []string{oauth.PKCEMethodS256},
+			"claims_supported": []string{
+				"sub",
+				"iss",
+				"aud",
+				"exp",
+				"iat",
+				"auth_time",
+				"nonce",
+				"preferred_username",
+				"email",
+				"email_verified",
+			},
+		})
+	}
+}
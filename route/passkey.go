@@ -0,0 +1,495 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/middleware"
+	"citadel/internal/passkey"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// passkeyCeremonyTTL is how long a client has to answer a WebAuthn challenge.
+const passkeyCeremonyTTL = 5 * time.Minute
+
+type passkeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// CloneWarning marks a passkey refused for sign in because its signature
+	// counter went backwards
+	CloneWarning bool `json:"clone_warning,omitempty"`
+}
+
+func newPasskeyResponse(c *passkey.Credential) passkeyResponse {
+	return passkeyResponse{
+		ID:           c.ID(),
+		Name:         c.Name,
+		CreatedAt:    c.CreatedAt,
+		LastUsedAt:   c.LastUsedAt,
+		CloneWarning: c.CloneWarning,
+	}
+}
+
+func BeginPasskeyRegistration(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	wa *webauthn.WebAuthn,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("begin passkey registration handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("begin passkey registration failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		account, err := passkey.LoadAccount(ctx, db, claims.UserId)
+		if err != nil {
+			log.Error("failed to load passkey account", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start registration"})
+			return
+		}
+
+		// Passkeys must be discoverable so login works without an email address
+		creation, session, err := wa.BeginRegistration(
+			account,
+			webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+			webauthn.WithExclusions(
+				webauthn.Credentials(account.WebAuthnCredentials()).CredentialDescriptors(),
+			),
+		)
+		if err != nil {
+			log.Error("failed to begin passkey registration", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start registration"})
+			return
+		}
+
+		ceremonyID := uuid.New().String()
+		err = cache.SaveCeremony(ctx, rdb, ceremonyID, session, passkeyCeremonyTTL)
+		if err != nil {
+			log.Error("failed to store passkey ceremony", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start registration"})
+			return
+		}
+
+		log.Info(
+			"begin passkey registration handler completed successfully",
+			"user_id", claims.UserId,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"ceremony_id": ceremonyID,
+			"options":     creation,
+		})
+	}
+}
+
+func FinishPasskeyRegistration(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	wa *webauthn.WebAuthn,
+) http.HandlerFunc {
+	type Request struct {
+		CeremonyID string          `json:"ceremony_id"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("finish passkey registration handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("finish passkey registration failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("finish passkey registration failed: invalid request body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		if req.Name == "" {
+			req.Name = "Passkey"
+		}
+
+		session, err := cache.ConsumeCeremony(ctx, rdb, req.CeremonyID)
+		if errors.Is(err, cache.ErrCeremonyNotFound) ||
+			(err == nil && !bytes.Equal(session.UserID, passkey.UserHandle(claims.UserId))) {
+			log.Warn("finish passkey registration failed: unknown ceremony")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Registration expired, please try again"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to load passkey ceremony", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to register passkey"})
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+		if err != nil {
+			log.Warn("finish passkey registration failed: invalid credential", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credential"})
+			return
+		}
+
+		account, err := passkey.LoadAccount(ctx, db, claims.UserId)
+		if err != nil {
+			log.Error("failed to load passkey account", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to register passkey"})
+			return
+		}
+
+		credential, err := wa.CreateCredential(account, *session, parsed)
+		if err != nil {
+			log.Warn("finish passkey registration failed: verification failed", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Passkey verification failed"})
+			return
+		}
+
+		exists, err := passkey.Exists(ctx, db, credential.ID)
+		if err != nil {
+			log.Error("failed to check passkey", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to register passkey"})
+			return
+		}
+		if exists {
+			log.Warn("finish passkey registration failed: credential already registered")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Passkey already registered"})
+			return
+		}
+
+		if err := passkey.Create(ctx, db, claims.UserId, req.Name, credential); err != nil {
+			log.Error("failed to store passkey", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to register passkey"})
+			return
+		}
+
+		log.Info(
+			"finish passkey registration handler completed successfully",
+			"user_id", claims.UserId,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(passkeyResponse{
+			ID:        passkey.EncodeID(credential.ID),
+			Name:      req.Name,
+			CreatedAt: time.Now().UTC(),
+		})
+	}
+}
+
+func ListPasskeys(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("list passkeys handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("list passkeys failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		credentials, err := passkey.ByUser(r.Context(), db, claims.UserId)
+		if err != nil {
+			log.Error("failed to list passkeys", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list passkeys"})
+			return
+		}
+
+		response := make([]passkeyResponse, len(credentials))
+		for i := range credentials {
+			response[i] = newPasskeyResponse(&credentials[i])
+		}
+
+		log.Info("list passkeys handler completed successfully", "count", len(response))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func DeletePasskey(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("delete passkey handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("delete passkey failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		id := r.PathValue("id")
+		err := passkey.Delete(r.Context(), db, claims.UserId, id)
+		if errors.Is(err, passkey.ErrNotFound) {
+			log.Warn("delete passkey failed: passkey not found", "id", id)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Passkey not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete passkey", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete passkey"})
+			return
+		}
+
+		log.Info("delete passkey handler completed successfully", "user_id", claims.UserId)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func BeginPasskeyLogin(rdb *redis.Client, wa *webauthn.WebAuthn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("begin passkey login handler started")
+
+		// A passkey replaces both the password and the second factor, so the
+		// authenticator must verify the user as well
+		assertion, session, err := wa.BeginDiscoverableLogin(
+			webauthn.WithUserVerification(protocol.VerificationRequired),
+		)
+		if err != nil {
+			log.Error("failed to begin passkey login", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start login"})
+			return
+		}
+
+		ceremonyID := uuid.New().String()
+		err = cache.SaveCeremony(r.Context(), rdb, ceremonyID, session, passkeyCeremonyTTL)
+		if err != nil {
+			log.Error("failed to store passkey ceremony", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start login"})
+			return
+		}
+
+		log.Info("begin passkey login handler completed successfully")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"ceremony_id": ceremonyID,
+			"options":     assertion,
+		})
+	}
+}
+
+func FinishPasskeyLogin(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	wa *webauthn.WebAuthn,
+	requireVerifiedEmail bool,
+	cookies CookieConfig,
+) http.HandlerFunc {
+	type Request struct {
+		CeremonyID string          `json:"ceremony_id"`
+		Credential json.RawMessage `json:"credential"`
+		Scope      string          `json:"scope"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("finish passkey login handler started")
+
+		ctx := r.Context()
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("finish passkey login failed: invalid request body", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		session, err := cache.ConsumeCeremony(ctx, rdb, req.CeremonyID)
+		if errors.Is(err, cache.ErrCeremonyNotFound) {
+			log.Warn("finish passkey login failed: unknown ceremony")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Login expired, please try again"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to load passkey ceremony", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Authentication error"})
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+		if err != nil {
+			log.Warn("finish passkey login failed: invalid credential", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credential"})
+			return
+		}
+
+		var account *passkey.Account
+		findAccount := func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := passkey.ParseUserHandle(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			account, err = passkey.LoadAccount(ctx, db, userID)
+			if err != nil {
+				return nil, err
+			}
+			return account, nil
+		}
+
+		_, credential, err := wa.ValidatePasskeyLogin(findAccount, *session, parsed)
+		if err != nil {
+			log.Warn("failed passkey login attempt", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Passkey verification failed"})
+			return
+		}
+		u := account.User
+
+		if err := passkey.RecordUse(ctx, db, credential); err != nil {
+			log.Error("failed to record passkey use", "error", err)
+		}
+
+		// The warning is stored with the credential, so it stays unusable until
+		// the user deletes it and registers the authenticator again
+		if credential.Authenticator.CloneWarning {
+			credentialID := passkey.EncodeID(credential.ID)
+			log.Warn(
+				"security event: passkey signature counter went backwards",
+				"security_event", "passkey_clone_warning",
+				"user_id", u.UserId,
+				"credential_id", credentialID,
+			)
+			recordAudit(r, db, audit.EventLoginFailure, 0, u.UserId, map[string]any{
+				"reason":        "passkey_clone_warning",
+				"credential_id": credentialID,
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Passkey may have been cloned; sign in another way and register it again",
+			})
+			return
+		}
+
+		if u.SuspendedAt != nil {
+			log.Warn("login blocked: account suspended", "user_id", u.UserId)
+			recordAudit(r, db, audit.EventLoginFailure, 0, u.UserId, map[string]any{
+				"reason": "suspended",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Account suspended"})
+			return
+		}
+
+		if requireVerifiedEmail && u.EmailVerifiedAt == nil {
+			log.Warn("login blocked: email not verified", "user_id", u.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Email address not verified"})
+			return
+		}
+
+		log.Info("starting session", "user_id", u.UserId)
+		accessToken, refreshToken, err := startSession(
+			ctx,
+			db,
+			rdb,
+			issuer,
+			u,
+			req.Scope,
+			deviceFrom(r),
+		)
+		if errors.Is(err, auth.ErrInvalidScope) {
+			log.Warn("finish passkey login validation failed: invalid scope", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Unknown or unavailable scope"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to start session", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventLoginSuccess, u.UserId, u.UserId, map[string]any{
+			"method": "passkey",
+		})
+		log.Info("finish passkey login handler completed successfully", "user_id", u.UserId)
+		writeSession(w, r, cookies, http.StatusOK, accessToken, refreshToken)
+	}
+}
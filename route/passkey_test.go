@@ -0,0 +1,312 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"citadel/internal/audit"
+	"citadel/internal/passkey/passkeytest"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const passkeyOrigin = "http://localhost:3000"
+
+func newPasskeyServer(t *testing.T) *testServer {
+	t.Helper()
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          "localhost",
+		RPDisplayName: "Citadel",
+		RPOrigins:     []string{passkeyOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestServer(t, func(c *Config) { c.WebAuthn = wa })
+}
+
+// registerPasskey adds a passkey held by device to the signed in account.
+func registerPasskey(
+	t *testing.T,
+	s *testServer,
+	accessToken string,
+	device *passkeytest.Authenticator,
+) {
+	t.Helper()
+
+	var begin struct {
+		CeremonyID string                      `json:"ceremony_id"`
+		Options    protocol.CredentialCreation `json:"options"`
+	}
+	s.mustDo(t, http.StatusOK, "POST", "/me/passkeys/register/begin", accessToken, nil, &begin)
+
+	credential, err := device.Create(begin.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finish := map[string]any{
+		"ceremony_id": begin.CeremonyID,
+		"name":        "laptop",
+		"credential":  credential,
+	}
+	path := "/me/passkeys/register/finish"
+	s.mustDo(t, http.StatusCreated, "POST", path, accessToken, finish, nil)
+}
+
+// passkeyCeremony is a started passkey login.
+type passkeyCeremony struct {
+	CeremonyID string                       `json:"ceremony_id"`
+	Options    protocol.CredentialAssertion `json:"options"`
+}
+
+func beginPasskeyLogin(t *testing.T, s *testServer) passkeyCeremony {
+	t.Helper()
+
+	var ceremony passkeyCeremony
+	s.mustDo(t, http.StatusOK, "POST", "/login/passkey/begin", "", nil, &ceremony)
+	return ceremony
+}
+
+func assert(t *testing.T, device *passkeytest.Authenticator, c passkeyCeremony) json.RawMessage {
+	t.Helper()
+
+	assertion, err := device.Get(c.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assertion
+}
+
+func finishPasskeyLogin(
+	t *testing.T,
+	s *testServer,
+	ceremonyID string,
+	assertion json.RawMessage,
+	out any,
+) int {
+	t.Helper()
+
+	return s.do(t, "POST", "/login/passkey/finish", "", map[string]any{
+		"ceremony_id": ceremonyID,
+		"credential":  assertion,
+	}, out)
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	s := newPasskeyServer(t)
+	password := registerUser(t, s, "alice", "alice@example.com")
+	device := passkeytest.New(passkeyOrigin)
+	registerPasskey(t, s, password.AccessToken, device)
+
+	c := beginPasskeyLogin(t, s)
+	var body map[string]string
+	got := finishPasskeyLogin(t, s, c.CeremonyID, assert(t, device, c), &body)
+	if got != http.StatusOK {
+		t.Fatalf("got status %d, want %d", got, http.StatusOK)
+	}
+	if len(body) != 2 || body["access_token"] == "" || body["refresh_token"] == "" {
+		t.Fatalf("got body %v, want an access and refresh token like /login", body)
+	}
+	passkey := tokenPair{AccessToken: body["access_token"], RefreshToken: body["refresh_token"]}
+
+	// The session is the same as one started with the password
+	for name, tokens := range map[string]tokenPair{"password": password, "passkey": passkey} {
+		claims, err := s.cfg.Issuer.Validate(tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("%s: invalid access token: %v", name, err)
+		}
+		if claims.Username != "alice" || claims.SessionID == "" {
+			t.Errorf("%s: got claims for %q in session %q",
+				name, claims.Username, claims.SessionID)
+		}
+		s.mustDo(t, http.StatusOK, "GET", "/me", tokens.AccessToken, nil, nil)
+
+		var refreshed tokenPair
+		s.mustDo(t, http.StatusOK, "POST", "/refresh", "", map[string]string{
+			"refresh_token": tokens.RefreshToken,
+		}, &refreshed)
+		if refreshed.AccessToken == "" || refreshed.RefreshToken == "" {
+			t.Errorf("%s: refresh returned %+v", name, refreshed)
+		}
+	}
+}
+
+// passkeyAccount is a server with an account whose passkey is on device.
+type passkeyAccount struct {
+	s      *testServer
+	device *passkeytest.Authenticator
+}
+
+func TestPasskeyLoginRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		// submit returns the ceremony and assertion to finish the login with
+		submit func(t *testing.T, a passkeyAccount) (string, json.RawMessage)
+	}{
+		{
+			name: "wrong challenge",
+			submit: func(t *testing.T, a passkeyAccount) (string, json.RawMessage) {
+				signed := beginPasskeyLogin(t, a.s)
+				other := beginPasskeyLogin(t, a.s)
+				return other.CeremonyID, assert(t, a.device, signed)
+			},
+		},
+		{
+			name: "replayed challenge",
+			submit: func(t *testing.T, a passkeyAccount) (string, json.RawMessage) {
+				c := beginPasskeyLogin(t, a.s)
+				assertion := assert(t, a.device, c)
+				first := finishPasskeyLogin(t, a.s, c.CeremonyID, assertion, nil)
+				if first != http.StatusOK {
+					t.Fatalf("got status %d for the first login, want %d", first, http.StatusOK)
+				}
+				return c.CeremonyID, assertion
+			},
+		},
+		{
+			name: "replayed assertion",
+			submit: func(t *testing.T, a passkeyAccount) (string, json.RawMessage) {
+				c := beginPasskeyLogin(t, a.s)
+				assertion := assert(t, a.device, c)
+				first := finishPasskeyLogin(t, a.s, c.CeremonyID, assertion, nil)
+				if first != http.StatusOK {
+					t.Fatalf("got status %d for the first login, want %d", first, http.StatusOK)
+				}
+				return beginPasskeyLogin(t, a.s).CeremonyID, assertion
+			},
+		},
+		{
+			name: "unknown credential",
+			submit: func(t *testing.T, a passkeyAccount) (string, json.RawMessage) {
+				// A passkey created for an account but never registered
+				tokens := registerUser(t, a.s, "mallory", "mallory@example.com")
+				var begin struct {
+					Options protocol.CredentialCreation `json:"options"`
+				}
+				path := "/me/passkeys/register/begin"
+				a.s.mustDo(t, http.StatusOK, "POST", path, tokens.AccessToken, nil, &begin)
+				stranger := passkeytest.New(passkeyOrigin)
+				if _, err := stranger.Create(begin.Options); err != nil {
+					t.Fatal(err)
+				}
+
+				c := beginPasskeyLogin(t, a.s)
+				return c.CeremonyID, assert(t, stranger, c)
+			},
+		},
+		{
+			name: "cloned authenticator",
+			submit: func(t *testing.T, a passkeyAccount) (string, json.RawMessage) {
+				// Another copy of the key has signed more assertions than this one
+				_, err := a.s.cfg.Db.Exec(`UPDATE webauthn_credentials SET sign_count = 100`)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c := beginPasskeyLogin(t, a.s)
+				return c.CeremonyID, assert(t, a.device, c)
+			},
+		},
+		{
+			name: "unknown ceremony",
+			submit: func(t *testing.T, a passkeyAccount) (string, json.RawMessage) {
+				return "unknown", assert(t, a.device, beginPasskeyLogin(t, a.s))
+			},
+		},
+		{
+			name: "wrong origin",
+			submit: func(t *testing.T, a passkeyAccount) (string, json.RawMessage) {
+				a.device.Origin = "http://evil.example"
+				c := beginPasskeyLogin(t, a.s)
+				return c.CeremonyID, assert(t, a.device, c)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPasskeyServer(t)
+			tokens := registerUser(t, s, "alice", "alice@example.com")
+			device := passkeytest.New(passkeyOrigin)
+			registerPasskey(t, s, tokens.AccessToken, device)
+
+			ceremonyID, assertion := tt.submit(t, passkeyAccount{s: s, device: device})
+			var body map[string]string
+			got := finishPasskeyLogin(t, s, ceremonyID, assertion, &body)
+			if got != http.StatusUnauthorized {
+				t.Fatalf("got status %d, want %d", got, http.StatusUnauthorized)
+			}
+			if body["access_token"] != "" || body["refresh_token"] != "" {
+				t.Errorf("got tokens for a rejected login: %v", body)
+			}
+		})
+	}
+}
+
+func TestPasskeyLoginCloneWarning(t *testing.T) {
+	s := newPasskeyServer(t)
+	tokens := registerUser(t, s, "alice", "alice@example.com")
+	device := passkeytest.New(passkeyOrigin)
+	registerPasskey(t, s, tokens.AccessToken, device)
+	login := func() int {
+		t.Helper()
+		c := beginPasskeyLogin(t, s)
+		return finishPasskeyLogin(t, s, c.CeremonyID, assert(t, device, c), nil)
+	}
+
+	_, err := s.cfg.Db.Exec(`UPDATE webauthn_credentials SET sign_count = 100`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := login(); got != http.StatusUnauthorized {
+		t.Fatalf("got status %d for a cloned passkey, want 401", got)
+	}
+
+	var details string
+	err = s.cfg.Db.Get(
+		&details,
+		`SELECT details FROM audit_events WHERE event_type = ?
+		ORDER BY event_id DESC LIMIT 1`,
+		audit.EventLoginFailure,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(details), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != "passkey_clone_warning" {
+		t.Errorf("got login failure reason %q, want passkey_clone_warning", event.Reason)
+	}
+
+	// The passkey stays refused even once its counter is ahead again
+	_, err = s.cfg.Db.Exec(`UPDATE webauthn_credentials SET sign_count = 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := login(); got != http.StatusUnauthorized {
+		t.Fatalf("got status %d for a flagged passkey, want 401", got)
+	}
+
+	var passkeys []struct {
+		ID           string `json:"id"`
+		CloneWarning bool   `json:"clone_warning"`
+	}
+	s.mustDo(t, http.StatusOK, "GET", "/me/passkeys", tokens.AccessToken, nil, &passkeys)
+	if len(passkeys) != 1 || !passkeys[0].CloneWarning {
+		t.Fatalf("got passkeys %+v, want the one flagged with clone_warning", passkeys)
+	}
+
+	// Registering the authenticator again makes it usable
+	path := "/me/passkeys/" + passkeys[0].ID
+	s.mustDo(t, http.StatusNoContent, "DELETE", path, tokens.AccessToken, nil, nil)
+	device = passkeytest.New(passkeyOrigin)
+	registerPasskey(t, s, tokens.AccessToken, device)
+	if got := login(); got != http.StatusOK {
+		t.Fatalf("got status %d for the registered again passkey, want 200", got)
+	}
+}
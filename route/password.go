@@ -0,0 +1,190 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"citadel/internal/audit"
+	"citadel/internal/mail"
+	"citadel/internal/middleware"
+	"citadel/internal/password"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// passwordResetTTL is how long a password reset link stays valid.
+const passwordResetTTL = time.Hour
+
+// ForgotPassword emails a reset link. The response is identical whether or not
+// the address has an account, and the lookup and mail delivery happen after
+// the response so timing does not give the answer away either.
+func ForgotPassword(db *sqlx.DB, mailer mail.Mailer, appURL string) http.HandlerFunc {
+	type Request struct {
+		Email string `json:"email"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("forgot password handler started")
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			log.Warn("forgot password validation failed: missing email")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Email is required"})
+			return
+		}
+
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			u, err := user.ByEmail(ctx, db, req.Email)
+			if err != nil {
+				log.Info("password reset requested for unknown email", "email", req.Email)
+				return
+			}
+
+			token, err := user.CreateReset(ctx, db, u.UserId, passwordResetTTL)
+			if err != nil {
+				log.Error("failed to create password reset", "error", err, "user_id", u.UserId)
+				return
+			}
+
+			link := fmt.Sprintf("%s/reset-password?token=%s", appURL, url.QueryEscape(token))
+			err = mailer.Send(ctx, mail.Message{
+				To:      u.Email,
+				Subject: "Reset your password",
+				Body: fmt.Sprintf(
+					"Hi %s,\n\n"+
+						"Someone asked to reset the password for your Citadel account. "+
+						"Choose a new password by opening this link:\n\n"+
+						"%s\n\n"+
+						"The link expires in 1 hour and can only be used once. "+
+						"If you did not ask for this, you can ignore this email.\n",
+					u.Username,
+					link,
+				),
+			})
+			if err != nil {
+				log.Error("failed to send password reset email", "error", err, "user_id", u.UserId)
+				return
+			}
+			log.Info("password reset email sent", "user_id", u.UserId)
+		}()
+
+		log.Info("forgot password handler completed")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an account exists for that email, a reset link has been sent",
+		})
+	}
+}
+
+func ResetPassword(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	hasher *password.Hasher,
+	policy *password.Policy,
+) http.HandlerFunc {
+	type Request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("reset password handler started")
+
+		ctx := r.Context()
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Error("failed to decode reset password request", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+
+		if req.Token == "" || req.Password == "" {
+			log.Warn("reset password validation failed: missing fields")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Token and password are required"})
+			return
+		}
+
+		u, err := user.ResetUser(ctx, db, req.Token)
+		if errors.Is(err, user.ErrResetInvalid) {
+			log.Warn("invalid password reset token")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired reset link"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to look up password reset", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to reset password"})
+			return
+		}
+
+		var policyErr *password.PolicyError
+		err = policy.Check(req.Password, u.Username, u.Email)
+		if errors.As(err, &policyErr) {
+			log.Warn("reset password validation failed: password policy", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error":      "Password does not meet requirements",
+				"violations": policyErr.Violations,
+			})
+			return
+		}
+		if err != nil {
+			log.Error("failed to check password policy", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to check password"})
+			return
+		}
+
+		userID, err := user.ConsumeReset(ctx, db, hasher, req.Token, req.Password)
+		if errors.Is(err, user.ErrResetInvalid) {
+			log.Warn("invalid password reset token")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired reset link"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to reset password", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to reset password"})
+			return
+		}
+		log.Info("password reset", "user_id", userID)
+		recordAudit(r, db, audit.EventPasswordReset, userID, userID, nil)
+
+		// Whoever held the old password must not keep a session
+		log.Info("revoking all user tokens", "user_id", userID)
+		if err := revokeUserTokens(ctx, db, rdb, userID); err != nil {
+			log.Error("failed to revoke user tokens", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Password reset but failed to revoke sessions"})
+			return
+		}
+
+		log.Info("reset password handler completed successfully", "user_id", userID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
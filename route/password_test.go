@@ -0,0 +1,29 @@
+package route
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"citadel/internal/user"
+)
+
+func TestResetPasswordRevocationFailure(t *testing.T) {
+	s := newTestServer(t)
+	session := registerUser(t, s, "alice", "alice@example.com")
+	id := userID(t, s, session.AccessToken)
+
+	token, err := user.CreateReset(context.Background(), s.cfg.Db, id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions cannot be revoked without Redis, so the reset must not claim success
+	s.cfg.Redis.Close()
+	body := map[string]string{"token": token, "password": "another horse battery staple"}
+	got := s.do(t, "POST", "/password/reset", "", body, nil)
+	if got != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", got)
+	}
+}
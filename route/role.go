@@ -0,0 +1,134 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"citadel/internal/audit"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+func GrantRole(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("grant role handler started")
+
+		ctx := r.Context()
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("grant role validation failed: invalid user ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+		role := r.PathValue("role")
+
+		if _, err := user.ByID(ctx, db, userID); err != nil {
+			log.Warn("grant role failed: user not found", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+
+		log.Info("granting role", "user_id", userID, "role", role)
+		if err := user.GrantRole(ctx, db, userID, role); err != nil {
+			log.Error("failed to grant role", "error", err, "user_id", userID, "role", role)
+			if err.Error() == "role not found" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Role not found"})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to grant role"})
+			return
+		}
+
+		roles, err := user.Roles(ctx, db, userID)
+		if err != nil {
+			log.Error("failed to fetch roles", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Role granted but failed to fetch"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventRoleGrant, actorID(r), userID, map[string]any{
+			"role": role,
+		})
+
+		log.Info("grant role handler completed successfully", "user_id", userID, "role", role)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{"user_id": userID, "roles": roles})
+	}
+}
+
+// RevokeRole removes a role from a user and signs them out everywhere, since
+// their live tokens still carry the role's permissions.
+func RevokeRole(db *sqlx.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("revoke role handler started")
+
+		ctx := r.Context()
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("revoke role validation failed: invalid user ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+		role := r.PathValue("role")
+
+		log.Info("revoking role", "user_id", userID, "role", role)
+		revoked, err := user.RevokeRole(ctx, db, userID, role)
+		if err != nil {
+			log.Error("failed to revoke role", "error", err, "user_id", userID, "role", role)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to revoke role"})
+			return
+		}
+		if !revoked {
+			log.Info("revoke role handler completed: role not held",
+				"user_id", userID,
+				"role", role,
+			)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// Tokens issued under the role, including impersonation tokens minted
+		// with it, must not keep its permissions
+		log.Info("revoking all user tokens", "user_id", userID)
+		revokeErr := revokeUserTokens(ctx, db, rdb, userID)
+
+		recordAudit(r, db, audit.EventRoleRevoke, actorID(r), userID, map[string]any{
+			"role":             role,
+			"sessions_revoked": revokeErr == nil,
+		})
+
+		if revokeErr != nil {
+			log.Error("failed to revoke user tokens", "error", revokeErr, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Role revoked but failed to revoke sessions"})
+			return
+		}
+
+		log.Info("revoke role handler completed successfully", "user_id", userID, "role", role)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
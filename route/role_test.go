@@ -0,0 +1,42 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRevokeRole(t *testing.T) {
+	s := newTestServer(t)
+	registerUser(t, s, "alice", "alice@example.com")
+	grantRole(t, s, "alice", "admin")
+	alice := login(t, s, "alice@example.com", "")
+	registerUser(t, s, "bob", "bob@example.com")
+	grantRole(t, s, "bob", "admin")
+	bob := login(t, s, "bob@example.com", "")
+	carol := registerUser(t, s, "carol", "carol@example.com")
+
+	var impersonation struct {
+		AccessToken string `json:"access_token"`
+	}
+	path := userPath(userID(t, s, carol.AccessToken)) + "/impersonate"
+	s.mustDo(t, http.StatusCreated, "POST", path, bob.AccessToken, nil, &impersonation)
+
+	// Revoking a role the user does not hold leaves their sessions alone
+	rolePath := userPath(userID(t, s, bob.AccessToken)) + "/roles/"
+	s.mustDo(t, http.StatusNoContent, "DELETE", rolePath+"nonexistent", alice.AccessToken, nil, nil)
+	s.mustDo(t, http.StatusOK, "GET", "/me", bob.AccessToken, nil, nil)
+
+	s.mustDo(t, http.StatusNoContent, "DELETE", rolePath+"admin", alice.AccessToken, nil, nil)
+	for name, token := range map[string]string{
+		"access token":        bob.AccessToken,
+		"impersonation token": impersonation.AccessToken,
+	} {
+		if got := s.do(t, "GET", "/me", token, nil, nil); got != http.StatusUnauthorized {
+			t.Errorf("got status %d for the %s, want 401", got, name)
+		}
+	}
+	refresh := map[string]string{"refresh_token": bob.RefreshToken}
+	if got := s.do(t, "POST", "/refresh", "", refresh, nil); got != http.StatusUnauthorized {
+		t.Errorf("got status %d for the refresh token, want 401", got)
+	}
+}
@@ -0,0 +1,222 @@
+package route
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/database"
+	"citadel/internal/logging"
+	"citadel/internal/mail"
+	"citadel/internal/password"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testServer runs the full router against a temporary database and an
+// in-memory Redis.
+type testServer struct {
+	*httptest.Server
+	cfg Config
+}
+
+// newTestServer starts a server with a fresh signing key and a cheap password
+// hasher. configure may adjust the config before the router is built.
+func newTestServer(t *testing.T, configure ...func(*Config)) *testServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "citadel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	key, err := auth.ParseKey("test", pem.EncodeToMemory(block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := auth.NewIssuer("test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher, err := password.New(password.Config{
+		Params: password.Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	broadcaster := logging.NewBroadcaster()
+	revocations := cache.NewRevocations(rdb, cache.RevocationConfig{TTL: time.Hour, Size: 1000})
+	cfg := Config{
+		Db:             db,
+		Redis:          rdb,
+		Revocations:    revocations,
+		Issuer:         issuer,
+		Logger:         logger,
+		Broadcaster:    broadcaster,
+		Mailer:         mail.NewLog("citadel@localhost", logger),
+		Hasher:         hasher,
+		PasswordPolicy: &password.Policy{},
+		AppURL:         "http://localhost:3000",
+		LogManager: logging.NewManager(
+			logging.Config{FilePath: filepath.Join(dir, "citadel.log")},
+			broadcaster,
+		),
+	}
+	for _, c := range configure {
+		c(&cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go cfg.Revocations.Run(ctx)
+
+	s := &testServer{Server: httptest.NewServer(Initialize(cfg)), cfg: cfg}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// do sends body as JSON, authenticated with token if it is not empty, and
+// decodes the response into out if it is not nil. It returns the status code.
+func (s *testServer) do(t *testing.T, method, path, token string, body, out any) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+// mustDo is do for requests that have to succeed with the given status.
+func (s *testServer) mustDo(t *testing.T, status int, method, path, token string, body, out any) {
+	t.Helper()
+
+	if got := s.do(t, method, path, token, body, out); got != status {
+		t.Fatalf("%s %s: got status %d, want %d", method, path, got, status)
+	}
+}
+
+// tokenPair is the body of a successful login.
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// registerUser creates an account and signs in to it with its password.
+func registerUser(t *testing.T, s *testServer, username, email string) tokenPair {
+	t.Helper()
+
+	credentials := map[string]string{
+		"username": username,
+		"email":    email,
+		"password": "correct horse battery staple",
+	}
+	s.mustDo(t, http.StatusCreated, "POST", "/register", "", credentials, nil)
+
+	var tokens tokenPair
+	s.mustDo(t, http.StatusOK, "POST", "/login", "", credentials, &tokens)
+	return tokens
+}
+
+// grantRole gives the user a role directly in the database. Tokens issued
+// before the grant do not carry it.
+func grantRole(t *testing.T, s *testServer, username, role string) {
+	t.Helper()
+
+	_, err := s.cfg.Db.Exec(
+		`INSERT INTO user_roles (user_id, role_id)
+		SELECT u.user_id, r.role_id FROM users u, roles r
+		WHERE u.username = ? AND r.name = ?`,
+		username,
+		role,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// login signs in with the password registerUser sets, narrowed to scope if
+// it is not empty.
+func login(t *testing.T, s *testServer, email, scope string) tokenPair {
+	t.Helper()
+
+	credentials := map[string]string{
+		"email":    email,
+		"password": "correct horse battery staple",
+		"scope":    scope,
+	}
+	var tokens tokenPair
+	s.mustDo(t, http.StatusOK, "POST", "/login", "", credentials, &tokens)
+	return tokens
+}
+
+// userID returns the ID of the user the access token was issued to.
+func userID(t *testing.T, s *testServer, accessToken string) int64 {
+	t.Helper()
+
+	var me struct {
+		UserID int64 `json:"user_id"`
+	}
+	s.mustDo(t, http.StatusOK, "GET", "/me", accessToken, nil, &me)
+	return me.UserID
+}
+
+func userPath(id int64) string {
+	return "/users/" + strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,183 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"citadel/internal/audit"
+	"citadel/internal/cache"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// revokeUserTokens signs the user out everywhere: access and API tokens issued
+// so far stop working and every refresh token family is revoked.
+func revokeUserTokens(ctx context.Context, db *sqlx.DB, rdb *redis.Client, userID int64) error {
+	epoch, err := user.BumpTokenEpoch(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	return publishTokenEpoch(ctx, rdb, userID, epoch)
+}
+
+// publishTokenEpoch makes a bumped epoch take effect immediately and drops
+// the refresh tokens that could mint new access tokens. Impersonation tokens
+// the user minted as an admin are ended too.
+func publishTokenEpoch(ctx context.Context, rdb *redis.Client, userID int64, epoch int64) error {
+	if err := cache.SetTokenEpoch(ctx, rdb, userID, epoch); err != nil {
+		return fmt.Errorf("failed to publish token epoch: %w", err)
+	}
+	if err := cache.DeleteUserRefresh(ctx, rdb, userID); err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
+	if _, err := cache.RevokeImpersonations(ctx, rdb, userID); err != nil {
+		return fmt.Errorf("failed to revoke impersonation tokens: %w", err)
+	}
+	return nil
+}
+
+func ListSessions(rdb *redis.Client) http.HandlerFunc {
+	type Session struct {
+		cache.Session
+		Current bool `json:"current"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("list sessions handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("list sessions failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		log.Info("listing sessions from redis", "user_id", claims.UserId)
+		sessions, err := cache.ListSessions(ctx, rdb, claims.UserId)
+		if err != nil {
+			log.Error("failed to list sessions", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list sessions"})
+			return
+		}
+
+		response := make([]Session, len(sessions))
+		for i, s := range sessions {
+			response[i] = Session{Session: s, Current: s.ID == claims.SessionID}
+		}
+
+		log.Info("list sessions handler completed successfully", "count", len(response))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func DeleteSession(db *sqlx.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("delete session handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("delete session failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		sessionID := r.PathValue("id")
+		session, err := cache.GetSession(ctx, rdb, sessionID)
+		if errors.Is(err, cache.ErrSessionNotFound) ||
+			(err == nil && session.UserID != claims.UserId) {
+			// Someone else's session looks the same as a missing one
+			log.Warn("delete session failed: session not found", "session_id", sessionID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to get session", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to revoke session"})
+			return
+		}
+
+		log.Info("revoking session", "user_id", claims.UserId, "session_id", sessionID)
+		if _, err := cache.RevokeFamily(ctx, rdb, sessionID); err != nil {
+			log.Error("failed to revoke session", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to revoke session"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventTokenRevoke, claims.UserId, claims.UserId, map[string]any{
+			"session_id": sessionID,
+		})
+
+		log.Info("delete session handler completed successfully", "session_id", sessionID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeUserSessions signs a user out of every session and invalidates their
+// access tokens.
+func RevokeUserSessions(db *sqlx.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("revoke user sessions handler started")
+
+		ctx := r.Context()
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn(
+				"revoke user sessions validation failed: invalid user ID",
+				"id", r.PathValue("id"),
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+
+		log.Info("revoking all user tokens", "user_id", userID)
+		err = revokeUserTokens(ctx, db, rdb, userID)
+		if errors.Is(err, user.ErrNotFound) {
+			log.Warn("revoke user sessions failed: user not found", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to revoke user tokens", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to revoke sessions"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventTokenRevoke, actorID(r), userID, map[string]any{
+			"scope": "all",
+		})
+
+		log.Info("revoke user sessions handler completed successfully", "user_id", userID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
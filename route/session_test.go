@@ -0,0 +1,112 @@
+package route
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"citadel/internal/user"
+)
+
+func TestRevokeUserTokens(t *testing.T) {
+	const (
+		oldPassword = "correct horse battery staple"
+		newPassword = "another horse battery staple"
+	)
+
+	tests := []struct {
+		name string
+		// revoke ends the sessions of the user with the given ID, acting as
+		// that user or as the admin. It returns the user's password afterwards.
+		revoke func(t *testing.T, s *testServer, id int64, self, admin string) string
+	}{
+		{
+			name: "password change",
+			revoke: func(t *testing.T, s *testServer, id int64, self, _ string) string {
+				body := map[string]string{"password": newPassword}
+				s.mustDo(t, http.StatusOK, "PATCH", userPath(id), self, body, nil)
+				return newPassword
+			},
+		},
+		{
+			name: "sign out everywhere",
+			revoke: func(t *testing.T, s *testServer, id int64, _, admin string) string {
+				path := userPath(id) + "/sessions"
+				s.mustDo(t, http.StatusNoContent, "DELETE", path, admin, nil, nil)
+				return oldPassword
+			},
+		},
+		{
+			name: "password reset",
+			revoke: func(t *testing.T, s *testServer, id int64, _, _ string) string {
+				token, err := user.CreateReset(context.Background(), s.cfg.Db, id, time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body := map[string]string{"token": token, "password": newPassword}
+				s.mustDo(t, http.StatusNoContent, "POST", "/password/reset", "", body, nil)
+				return newPassword
+			},
+		},
+		{
+			name: "suspension",
+			revoke: func(t *testing.T, s *testServer, id int64, _, admin string) string {
+				path := userPath(id) + "/suspension"
+				s.mustDo(t, http.StatusNoContent, "PUT", path, admin, nil, nil)
+				s.mustDo(t, http.StatusNoContent, "DELETE", path, admin, nil, nil)
+				return oldPassword
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			admin := registerUser(t, s, "admin", "admin@example.com")
+			grantRole(t, s, "admin", "admin")
+			admin = login(t, s, "admin@example.com", "")
+			session := registerUser(t, s, "alice", "alice@example.com")
+			id := userID(t, s, session.AccessToken)
+
+			var created struct {
+				Token string `json:"token"`
+			}
+			create := func(accessToken string) {
+				t.Helper()
+				body := map[string]string{"name": "ci"}
+				s.mustDo(t, http.StatusCreated, "POST", "/me/tokens", accessToken, body, &created)
+			}
+			create(session.AccessToken)
+
+			password := tt.revoke(t, s, id, session.AccessToken, admin.AccessToken)
+
+			revoked := map[string]int{
+				"access token": s.do(t, "GET", "/me", session.AccessToken, nil, nil),
+				"refresh token": s.do(t, "POST", "/refresh", "", map[string]string{
+					"refresh_token": session.RefreshToken,
+				}, nil),
+				"api token": s.do(t, "GET", "/me", created.Token, nil, nil),
+			}
+			for credential, got := range revoked {
+				if got != http.StatusUnauthorized {
+					t.Errorf("got status %d for the %s, want 401", got, credential)
+				}
+			}
+
+			// Credentials issued afterwards work, and the ended token is not listed
+			var current tokenPair
+			credentials := map[string]string{"email": "alice@example.com", "password": password}
+			s.mustDo(t, http.StatusOK, "POST", "/login", "", credentials, &current)
+			create(current.AccessToken)
+			s.mustDo(t, http.StatusOK, "GET", "/me", created.Token, nil, nil)
+
+			var tokens []struct {
+				ID int64 `json:"id"`
+			}
+			s.mustDo(t, http.StatusOK, "GET", "/me/tokens", current.AccessToken, nil, &tokens)
+			if len(tokens) != 1 {
+				t.Errorf("got %d api tokens listed, want only the new one", len(tokens))
+			}
+		})
+	}
+}
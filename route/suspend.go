@@ -0,0 +1,122 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"citadel/internal/audit"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+func SuspendUser(db *sqlx.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("suspend user handler started")
+
+		ctx := r.Context()
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("suspend user failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("suspend user validation failed: invalid user ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+
+		if userID == claims.UserId {
+			log.Warn("suspend user validation failed: cannot suspend self", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "You cannot suspend your own account"})
+			return
+		}
+
+		epoch, err := user.Suspend(ctx, db, userID)
+		if errors.Is(err, user.ErrNotFound) {
+			log.Warn("suspend user failed: user not found", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to suspend user", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to suspend user"})
+			return
+		}
+		log.Warn("security event: account suspended",
+			"security_event", "account_suspended",
+			"user_id", userID,
+			"suspended_by", claims.UserId,
+		)
+		recordAudit(r, db, audit.EventUserSuspend, claims.UserId, userID, nil)
+
+		if err := publishTokenEpoch(ctx, rdb, userID, epoch); err != nil {
+			log.Error("failed to revoke user tokens", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "User suspended but failed to revoke sessions"})
+			return
+		}
+
+		log.Info("suspend user handler completed successfully", "user_id", userID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func UnsuspendUser(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("unsuspend user handler started")
+
+		ctx := r.Context()
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			log.Warn("unsuspend user validation failed: invalid user ID", "id", r.PathValue("id"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user ID"})
+			return
+		}
+
+		err = user.Unsuspend(ctx, db, userID)
+		if errors.Is(err, user.ErrNotFound) {
+			log.Warn("unsuspend user failed: user not found", "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Error("failed to unsuspend user", "error", err, "user_id", userID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to unsuspend user"})
+			return
+		}
+
+		recordAudit(r, db, audit.EventUserUnsuspend, actorID(r), userID, nil)
+
+		log.Info("unsuspend user handler completed successfully", "user_id", userID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,225 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/internal/cache"
+	"citadel/internal/middleware"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Login throttling policies. An address can be shared by many users behind a
+// NAT, so it gets far more headroom than a single account.
+var (
+	emailThrottle = cache.ThrottlePolicy{
+		Window:          15 * time.Minute,
+		DelayAfter:      3,
+		BaseDelay:       2 * time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+	}
+	ipThrottle = cache.ThrottlePolicy{
+		Window:          15 * time.Minute,
+		DelayAfter:      20,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		LockoutAfter:    100,
+		LockoutDuration: 15 * time.Minute,
+	}
+)
+
+// loginThrottleKey pairs a throttled key with its policy.
+type loginThrottleKey struct {
+	key    string
+	policy cache.ThrottlePolicy
+}
+
+func emailThrottleKey(email string) string {
+	return "login:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+// loginThrottleKeys returns the keys a login attempt is counted against.
+func loginThrottleKeys(r *http.Request, email string) []loginThrottleKey {
+	return []loginThrottleKey{
+		{key: emailThrottleKey(email), policy: emailThrottle},
+		{key: ipThrottleKey(middleware.ClientIP(r)), policy: ipThrottle},
+	}
+}
+
+// checkLoginThrottle rejects the request with 429 and Retry-After when any key
+// is locked out or still inside its progressive delay. Returns false if the
+// request was rejected.
+func checkLoginThrottle(
+	w http.ResponseWriter,
+	r *http.Request,
+	rdb *redis.Client,
+	keys []loginThrottleKey,
+) bool {
+	log := middleware.GetLogger(r)
+
+	var retryAfter time.Duration
+	locked := false
+	for _, k := range keys {
+		status, err := cache.CheckThrottle(r.Context(), rdb, k.policy, k.key)
+		if err != nil {
+			log.Error("failed to check login throttle", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Authentication service unavailable"})
+			return false
+		}
+		if status.RetryAfter > retryAfter {
+			retryAfter = status.RetryAfter
+		}
+		locked = locked || status.Locked
+	}
+
+	if retryAfter == 0 {
+		return true
+	}
+
+	log.Warn("login throttled", "locked", locked, "retry_after_ms", retryAfter.Milliseconds())
+	message := "Too many failed login attempts, try again later"
+	if locked {
+		message = "Too many failed login attempts, login is temporarily locked"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	return false
+}
+
+// recordLoginFailure counts a failed login against every key and logs a
+// security event for each key that just became locked out.
+func recordLoginFailure(
+	ctx context.Context,
+	log *slog.Logger,
+	rdb *redis.Client,
+	keys []loginThrottleKey,
+) {
+	for _, k := range keys {
+		locked, err := cache.RecordFailure(ctx, rdb, k.policy, k.key)
+		if err != nil {
+			log.Error("failed to record login failure", "error", err, "key", k.key)
+			continue
+		}
+		if locked {
+			log.Warn("security event: login locked out",
+				"security_event", "login_lockout",
+				"key", k.key,
+				"duration", k.policy.LockoutDuration.String(),
+			)
+		}
+	}
+}
+
+// lockoutKeys reads the email and ip query parameters of the lockout admin API.
+func lockoutKeys(r *http.Request) map[string]string {
+	keys := map[string]string{}
+	if email := r.URL.Query().Get("email"); email != "" {
+		keys["email"] = emailThrottleKey(email)
+	}
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		keys["ip"] = ipThrottleKey(ip)
+	}
+	return keys
+}
+
+func GetLockout(rdb *redis.Client) http.HandlerFunc {
+	policies := map[string]cache.ThrottlePolicy{"email": emailThrottle, "ip": ipThrottle}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("get lockout handler started")
+
+		keys := lockoutKeys(r)
+		if len(keys) == 0 {
+			log.Warn("get lockout validation failed: missing email or ip")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Email or ip is required"})
+			return
+		}
+
+		response := map[string]any{}
+		for kind, key := range keys {
+			status, err := cache.CheckThrottle(r.Context(), rdb, policies[kind], key)
+			if err != nil {
+				log.Error("failed to check login throttle", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get lockout"})
+				return
+			}
+			response[kind] = map[string]any{
+				"failures":            status.Failures,
+				"locked":              status.Locked,
+				"retry_after_seconds": int(math.Ceil(status.RetryAfter.Seconds())),
+			}
+		}
+
+		log.Info("get lockout handler completed successfully")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func DeleteLockout(rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("delete lockout handler started")
+
+		claims, ok := middleware.GetClaims(r)
+		if !ok {
+			log.Warn("delete lockout failed: no claims in context")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		keys := lockoutKeys(r)
+		if len(keys) == 0 {
+			log.Warn("delete lockout validation failed: missing email or ip")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Email or ip is required"})
+			return
+		}
+
+		for _, key := range keys {
+			unlocked, err := cache.ResetThrottle(r.Context(), rdb, key)
+			if err != nil {
+				log.Error("failed to reset login throttle", "error", err, "key", key)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to clear lockout"})
+				return
+			}
+			log.Warn("security event: login lockout cleared",
+				"security_event", "login_unlock",
+				"key", key,
+				"was_locked", unlocked,
+				"admin_id", claims.UserId,
+			)
+		}
+
+		log.Info("delete lockout handler completed successfully")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
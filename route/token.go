@@ -0,0 +1,124 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// refreshTTL is how long a refresh token family stays alive without being used.
+const refreshTTL = 24 * time.Hour
+
+// loadSubject resolves the roles and permissions that go into a user's access
+// token. The subject is granted every scope the user holds.
+func loadSubject(ctx context.Context, db *sqlx.DB, u *user.User) (auth.Subject, error) {
+	roles, err := user.Roles(ctx, db, u.UserId)
+	if err != nil {
+		return auth.Subject{}, err
+	}
+	permissions, err := user.Permissions(ctx, db, u.UserId)
+	if err != nil {
+		return auth.Subject{}, err
+	}
+	return auth.Subject{
+		UserId:      u.UserId,
+		Username:    u.Username,
+		Email:       u.Email,
+		Roles:       roles,
+		Permissions: permissions,
+		Scope:       auth.FullScope(permissions),
+		TokenEpoch:  u.TokenEpoch,
+	}, nil
+}
+
+// startSession begins a new refresh token family for the user and issues the
+// first access/refresh pair from it. Every sign-in flow ends here, so it also
+// records the user's last login. A non-empty scope narrows the session and
+// fails with auth.ErrInvalidScope if the user does not hold all of it.
+func startSession(
+	ctx context.Context,
+	db *sqlx.DB,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	u *user.User,
+	scope string,
+	device cache.Device,
+) (accessToken string, refreshToken string, err error) {
+	subject, err := loadSubject(ctx, db, u)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load user roles: %w", err)
+	}
+	if scope != "" {
+		scope, err = auth.GrantScope(scope, subject.Scope)
+		if err != nil {
+			return "", "", err
+		}
+		subject.Scope = scope
+	}
+
+	refreshToken = uuid.New().String()
+	familyID, err := cache.StartFamily(
+		ctx,
+		rdb,
+		refreshToken,
+		u.UserId,
+		scope,
+		device,
+		refreshTTL,
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	subject.SessionID = familyID
+	accessToken, err = issueAccessToken(ctx, rdb, issuer, subject)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := user.RecordLogin(ctx, db, u.UserId); err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// deviceFrom captures the client metadata recorded against a session.
+func deviceFrom(r *http.Request) cache.Device {
+	return cache.Device{
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// issueAccessToken signs an access token and records it against the subject's
+// token family so revoking the family also revokes the access token.
+func issueAccessToken(
+	ctx context.Context,
+	rdb *redis.Client,
+	issuer *auth.Issuer,
+	subject auth.Subject,
+) (string, error) {
+	token, claims, err := issuer.GenerateAccessToken(subject)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	if subject.SessionID != "" {
+		err := cache.TrackAccess(ctx, rdb, subject.SessionID, claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			return "", fmt.Errorf("failed to track access token: %w", err)
+		}
+	}
+
+	return token, nil
+}
@@ -1,14 +1,28 @@
 package route
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
+	"citadel/internal/audit"
+	"citadel/internal/auth"
+	"citadel/internal/mail"
 	"citadel/internal/middleware"
+	"citadel/internal/password"
 	"citadel/internal/user"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultUserLimit = 50
+	maxUserLimit     = 200
 )
 
 func ListUsers(db *sqlx.DB) http.HandlerFunc {
@@ -16,9 +30,25 @@ func ListUsers(db *sqlx.DB) http.HandlerFunc {
 		log := middleware.GetLogger(r)
 		log.Info("list users handler started")
 
+		filter, err := parseUserFilter(r)
+		if err != nil {
+			log.Warn("list users validation failed", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
 		ctx := r.Context()
-		log.Info("querying all users from database")
-		users, err := user.List(ctx, db)
+		log.Info("querying users from database", "sort", filter.Sort, "limit", filter.Limit)
+		page, err := user.List(ctx, db, filter)
+		if errors.Is(err, user.ErrInvalidCursor) {
+			log.Warn("list users validation failed: invalid cursor")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid cursor"})
+			return
+		}
 		if err != nil {
 			log.Error("failed to list users", "error", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -27,14 +57,96 @@ func ListUsers(db *sqlx.DB) http.HandlerFunc {
 			return
 		}
 
-		log.Info("list users handler completed successfully", "count", len(users))
+		response := map[string]any{"users": page.Users}
+		if page.NextCursor != "" {
+			response["next_cursor"] = page.NextCursor
+		}
+		if page.Total != nil {
+			response["total"] = *page.Total
+		}
+
+		log.Info("list users handler completed successfully", "count", len(page.Users))
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(users)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// parseUserFilter reads the GET /users query. Dates sort newest first and
+// names alphabetically unless order says otherwise.
+func parseUserFilter(r *http.Request) (user.ListFilter, error) {
+	query := r.URL.Query()
+	filter := user.ListFilter{
+		UsernamePrefix: query.Get("username_prefix"),
+		EmailPrefix:    query.Get("email_prefix"),
+		Sort:           "created_at",
+		Cursor:         query.Get("cursor"),
+		Limit:          defaultUserLimit,
 	}
+
+	times := map[string]**time.Time{
+		"created_since":    &filter.CreatedSince,
+		"created_until":    &filter.CreatedUntil,
+		"last_login_since": &filter.LastLoginSince,
+		"last_login_until": &filter.LastLoginUntil,
+	}
+	for name, field := range times {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s time format (use RFC3339): %s", name, v)
+			}
+			*field = &t
+		}
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		if !user.SortColumn(sort) {
+			return filter, fmt.Errorf(
+				"invalid sort: %s (use created_at, last_login, username or email)",
+				sort,
+			)
+		}
+		filter.Sort = sort
+	}
+	switch order := query.Get("order"); order {
+	case "":
+		filter.Descending = filter.Sort == "created_at" || filter.Sort == "last_login"
+	case "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, fmt.Errorf("invalid order: %s (use asc or desc)", order)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxUserLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxUserLimit)
+		}
+		filter.Limit = n
+	}
+
+	if total := query.Get("total"); total != "" {
+		withTotal, err := strconv.ParseBool(total)
+		if err != nil {
+			return filter, fmt.Errorf("invalid total: %s", total)
+		}
+		filter.WithTotal = withTotal
+	}
+
+	return filter, nil
 }
 
-func UpdateUser(db *sqlx.DB) http.HandlerFunc {
+func UpdateUser(
+	db *sqlx.DB,
+	hasher *password.Hasher,
+	policy *password.Policy,
+	rdb *redis.Client,
+	mailer mail.Mailer,
+	issuer *auth.Issuer,
+	appURL string,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := middleware.GetLogger(r)
 		log.Info("update user handler started")
@@ -68,8 +180,67 @@ func UpdateUser(db *sqlx.DB) http.HandlerFunc {
 			return
 		}
 
+		// The password and email are how the user signs in and recovers the account
+		claims, ok := middleware.GetClaims(r)
+		if ok && claims.Impersonated() && (req.Password != nil || req.Email != nil) {
+			log.Warn("update user failed: credential change while impersonating",
+				"user_id", userID,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Not allowed while impersonating"})
+			return
+		}
+
+		if req.Password != nil {
+			existing, err := user.ByID(ctx, db, userID)
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Warn("update user failed: user not found", "user_id", userID)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+				return
+			}
+			if err != nil {
+				log.Error("failed to fetch user", "error", err, "user_id", userID)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update user"})
+				return
+			}
+
+			// Check against the account as it will be after the update
+			username, email := existing.Username, existing.Email
+			if req.Username != nil {
+				username = *req.Username
+			}
+			if req.Email != nil {
+				email = *req.Email
+			}
+			var policyErr *password.PolicyError
+			err = policy.Check(*req.Password, username, email)
+			if errors.As(err, &policyErr) {
+				log.Warn("update user validation failed: password policy", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]any{
+					"error":      "Password does not meet requirements",
+					"violations": policyErr.Violations,
+				})
+				return
+			}
+			if err != nil {
+				log.Error("failed to check password policy", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to check password"})
+				return
+			}
+		}
+
 		log.Info("updating user in database", "user_id", userID)
-		if err := user.Update(ctx, db, userID, req); err != nil {
+		if err := user.Update(ctx, db, hasher, userID, req); err != nil {
 			log.Error("failed to update user", "error", err, "user_id", userID)
 			if err.Error() == "user not found" {
 				w.Header().Set("Content-Type", "application/json")
@@ -83,6 +254,33 @@ func UpdateUser(db *sqlx.DB) http.HandlerFunc {
 			return
 		}
 
+		// Whoever held the old password must not keep a session
+		if req.Password != nil {
+			log.Info("revoking all user tokens", "user_id", userID)
+			if err := revokeUserTokens(ctx, db, rdb, userID); err != nil {
+				log.Error("failed to revoke user tokens", "error", err, "user_id", userID)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).
+					Encode(map[string]string{"error": "User updated but failed to revoke sessions"})
+				return
+			}
+		}
+
+		var changed []string
+		if req.Username != nil {
+			changed = append(changed, "username")
+		}
+		if req.Email != nil {
+			changed = append(changed, "email")
+		}
+		if req.Password != nil {
+			changed = append(changed, "password")
+		}
+		recordAudit(r, db, audit.EventUserUpdate, actorID(r), userID, map[string]any{
+			"fields": changed,
+		})
+
 		log.Info("fetching updated user from database", "user_id", userID)
 		updatedUser, err := user.ByID(ctx, db, userID)
 		if err != nil {
@@ -94,6 +292,14 @@ func UpdateUser(db *sqlx.DB) http.HandlerFunc {
 			return
 		}
 
+		if req.Email != nil && updatedUser.EmailVerifiedAt == nil {
+			log.Info("email changed, sending verification email", "user_id", userID)
+			err := sendVerificationEmail(ctx, mailer, issuer, appURL, updatedUser)
+			if err != nil {
+				log.Error("failed to send verification email", "error", err)
+			}
+		}
+
 		log.Info("update user handler completed successfully", "user_id", userID)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -0,0 +1,178 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"citadel/internal/auth"
+	"citadel/internal/cache"
+	"citadel/internal/mail"
+	"citadel/internal/middleware"
+	"citadel/internal/user"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// verifyEmailTTL is how long an email verification link stays valid.
+	verifyEmailTTL = 24 * time.Hour
+	// verificationLimit is how many links one address may be resent per window.
+	verificationLimit  = 3
+	verificationWindow = time.Hour
+)
+
+// sendVerificationEmail mails the user a signed link that proves they own their address.
+func sendVerificationEmail(
+	ctx context.Context,
+	mailer mail.Mailer,
+	issuer *auth.Issuer,
+	appURL string,
+	u *user.User,
+) error {
+	token, _, err := issuer.GeneratePurposeToken(
+		auth.PurposeVerifyEmail,
+		u.UserId,
+		u.Email,
+		verifyEmailTTL,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", appURL, url.QueryEscape(token))
+	return mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"Confirm your email address for Citadel by opening this link:\n\n"+
+				"%s\n\n"+
+				"The link expires in 24 hours. "+
+				"If you did not create an account, you can ignore this email.\n",
+			u.Username,
+			link,
+		),
+	})
+}
+
+func VerifyEmail(db *sqlx.DB, issuer *auth.Issuer) http.HandlerFunc {
+	type Request struct {
+		Token string `json:"token"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("verify email handler started")
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			log.Warn("verify email validation failed: missing token")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Token is required"})
+			return
+		}
+
+		claims, err := issuer.ValidatePurposeToken(auth.PurposeVerifyEmail, req.Token)
+		if err != nil {
+			log.Warn("invalid email verification token", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		log.Info("marking email verified", "user_id", claims.UserId)
+		if err := user.MarkEmailVerified(r.Context(), db, claims.UserId, claims.Email); err != nil {
+			log.Warn("failed to verify email", "error", err, "user_id", claims.UserId)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		log.Info("verify email handler completed successfully", "user_id", claims.UserId)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResendVerification sends a fresh verification link. It always answers 202,
+// and the lookup and mail delivery happen after the response, so it cannot be
+// used to discover which addresses have accounts. Each address may only ask
+// for a few links an hour.
+func ResendVerification(
+	db *sqlx.DB,
+	rdb *redis.Client,
+	mailer mail.Mailer,
+	issuer *auth.Issuer,
+	appURL string,
+) http.HandlerFunc {
+	type Request struct {
+		Email string `json:"email"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := middleware.GetLogger(r)
+		log.Info("resend verification handler started")
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			log.Warn("resend verification validation failed: missing email")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Email is required"})
+			return
+		}
+
+		count, retryAfter, err := cache.CountVerificationRequest(
+			r.Context(),
+			rdb,
+			req.Email,
+			verificationWindow,
+		)
+		if err != nil {
+			log.Error("failed to count verification request", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).
+				Encode(map[string]string{"error": "Authentication service unavailable"})
+			return
+		}
+		if count > verificationLimit {
+			log.Warn("verification requests throttled", "email", req.Email, "count", count)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Too many verification emails requested, try again later",
+			})
+			return
+		}
+
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			u, err := user.ByEmail(ctx, db, req.Email)
+			switch {
+			case err != nil:
+				log.Info("resend verification for unknown email", "email", req.Email)
+			case u.EmailVerifiedAt != nil:
+				log.Info("resend verification for verified email", "user_id", u.UserId)
+			default:
+				log.Info("sending verification email", "user_id", u.UserId)
+				if err := sendVerificationEmail(ctx, mailer, issuer, appURL, u); err != nil {
+					log.Error("failed to send verification email", "error", err)
+				}
+			}
+		}()
+
+		log.Info("resend verification handler completed")
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
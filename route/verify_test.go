@@ -0,0 +1,32 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResendVerificationLimit(t *testing.T) {
+	s := newTestServer(t)
+	registerUser(t, s, "alice", "alice@example.com")
+
+	// Known and unknown addresses are limited alike
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		body := map[string]string{"email": email}
+		for range verificationLimit {
+			s.mustDo(t, http.StatusAccepted, "POST", "/verify-email/resend", "", body, nil)
+		}
+		got := s.do(t, "POST", "/verify-email/resend", "", body, nil)
+		if got != http.StatusTooManyRequests {
+			t.Errorf("%s: got status %d past the limit, want 429", email, got)
+		}
+	}
+
+	// The limit is per address, however it is typed
+	body := map[string]string{"email": " Alice@Example.com"}
+	got := s.do(t, "POST", "/verify-email/resend", "", body, nil)
+	if got != http.StatusTooManyRequests {
+		t.Errorf("got status %d for the address in another case, want 429", got)
+	}
+	body = map[string]string{"email": "bob@example.com"}
+	s.mustDo(t, http.StatusAccepted, "POST", "/verify-email/resend", "", body, nil)
+}
@@ -6,5 +6,143 @@ CREATE TABLE IF NOT EXISTS users (
 	salt BLOB NOT NULL,
 	last_login DATETIME,
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	email_verified_at DATETIME,
+	token_epoch INTEGER NOT NULL DEFAULT 0,
+	suspended_at DATETIME,
+	deleted_at DATETIME
 );
+
+CREATE INDEX IF NOT EXISTS idx_users_created ON users(created_at, user_id);
+CREATE INDEX IF NOT EXISTS idx_users_last_login ON users(COALESCE(last_login, ''), user_id);
+
+CREATE TABLE IF NOT EXISTS roles (
+	role_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	permission_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+	permission_id INTEGER NOT NULL REFERENCES permissions(permission_id) ON DELETE CASCADE,
+	PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	confirmed_at DATETIME,
+	last_used_step INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+CREATE TABLE IF NOT EXISTS password_resets (
+	reset_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user ON password_resets(user_id);
+
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	secret_hash TEXT,
+	redirect_uris TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	token_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL DEFAULT '',
+	expires_at DATETIME,
+	last_used_at DATETIME,
+	last_used_ip TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	credential_id BLOB PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	public_key BLOB NOT NULL,
+	attestation_type TEXT NOT NULL DEFAULT '',
+	transports TEXT NOT NULL DEFAULT '',
+	flags INTEGER NOT NULL DEFAULT 0,
+	aaguid BLOB,
+	sign_count INTEGER NOT NULL DEFAULT 0,
+	clone_warning BOOLEAN NOT NULL DEFAULT 0,
+	last_used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+
+CREATE TABLE IF NOT EXISTS deleted_users (
+	user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+	username TEXT NOT NULL,
+	email TEXT NOT NULL,
+	purge_after DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_deleted_users_purge ON deleted_users(purge_after);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_type TEXT NOT NULL,
+	actor_id INTEGER,
+	target_id INTEGER,
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	details TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	impersonator_id INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events(target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events(event_type);
+CREATE INDEX IF NOT EXISTS idx_audit_events_impersonator ON audit_events(impersonator_id);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;